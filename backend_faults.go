@@ -0,0 +1,312 @@
+package backendtests
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/emersion/go-imap"
+	move "github.com/emersion/go-imap-move"
+	"github.com/emersion/go-imap/backend"
+	"github.com/google/go-cmp/cmp"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+const faultsUser = "faults"
+
+// faultMaxWrites is the maximum amount of storage writes that are failed one
+// by one for each operation, operations used in tests are expected to do
+// less writes.
+const faultMaxWrites = 64
+
+var (
+	errInjectedWrite = errors.New("backendtests: injected write failure")
+	errInjectedRead  = errors.New("backendtests: injected read failure")
+)
+
+// faultScenario is operation that is interrupted by injected faults. It is
+// executed on Fault1 mailbox created by setupFaults.
+type faultScenario struct {
+	name string
+	// needMove is true if operation requires move.Mailbox interface.
+	needMove bool
+	run      func(u backend.User, mbox backend.Mailbox) error
+}
+
+var faultScenarios = []faultScenario{
+	{
+		name: "CreateMessage",
+		run: func(u backend.User, mbox backend.Mailbox) error {
+			return mbox.CreateMessage([]string{"$Fault4"}, baseDate, strings.NewReader(testMailString))
+		},
+	},
+	{
+		name: "CopyMessages",
+		run: func(u backend.User, mbox backend.Mailbox) error {
+			seq, _ := imap.ParseSeqSet("1:*")
+			return mbox.CopyMessages(false, seq, "Fault2")
+		},
+	},
+	{
+		name:     "MoveMessages",
+		needMove: true,
+		run: func(u backend.User, mbox backend.Mailbox) error {
+			seq, _ := imap.ParseSeqSet("1:2")
+			return mbox.(move.Mailbox).MoveMessages(false, seq, "Fault2")
+		},
+	},
+	{
+		name: "Expunge",
+		run: func(u backend.User, mbox backend.Mailbox) error {
+			return mbox.Expunge()
+		},
+	},
+	{
+		name: "RenameMailbox",
+		run: func(u backend.User, mbox backend.Mailbox) error {
+			return u.RenameMailbox("Fault1", "Fault3")
+		},
+	},
+}
+
+// setupFaults creates mailboxes used by fault scenarios: Fault1 with three
+// messages (two of them have \Deleted flag) and Fault2 with one message.
+func setupFaults(t *testing.T, b Backend) (backend.User, backend.Mailbox) {
+	t.Helper()
+
+	u := getNamedUser(t, b, faultsUser)
+	mbox := getNamedMbox(t, u, "Fault1")
+	for _, flags := range [][]string{{"$Fault1"}, {"$Fault2", imap.DeletedFlag}, {"$Fault3", imap.DeletedFlag}} {
+		assert.NilError(t, mbox.CreateMessage(flags, baseDate, strings.NewReader(testMailString)))
+	}
+	mbox2 := getNamedMbox(t, u, "Fault2")
+	assert.NilError(t, mbox2.CreateMessage([]string{"$Fault0"}, baseDate, strings.NewReader(testMailString)))
+	return u, mbox
+}
+
+// faultMailbox is the state of mailbox that should be kept after faults.
+type faultMailbox struct {
+	uidNext uint32
+	uids    []uint32
+	// msgs contains flags and hash of body for each message.
+	msgs []string
+}
+
+func captureFaults(t *testing.T, u backend.User) map[string]faultMailbox {
+	t.Helper()
+
+	mboxes, err := u.ListMailboxes(false)
+	assert.NilError(t, err)
+	state := make(map[string]faultMailbox, len(mboxes))
+	for _, mbox := range mboxes {
+		state[mbox.Name()] = captureFaultMailbox(t, mbox)
+	}
+	return state
+}
+
+func captureFaultMailbox(t *testing.T, mbox backend.Mailbox) faultMailbox {
+	t.Helper()
+
+	status, err := mbox.Status([]imap.StatusItem{imap.StatusUidNext})
+	assert.NilError(t, err, "Status %s", mbox.Name())
+	res := faultMailbox{uidNext: status.UidNext, uids: []uint32{}, msgs: []string{}}
+
+	seq, _ := imap.ParseSeqSet("1:*")
+	ch := make(chan *imap.Message, 10)
+	done := make(chan error, 1)
+	go func() {
+		done <- mbox.ListMessages(false, seq, []imap.FetchItem{imap.FetchUid, imap.FetchFlags, "BODY.PEEK[]"}, ch)
+	}()
+	for msg := range ch {
+		var body []byte
+		for _, literal := range msg.Body {
+			body, err = ioutil.ReadAll(literal)
+			assert.NilError(t, err)
+		}
+		sum := sha256.Sum256(body)
+		res.uids = append(res.uids, msg.Uid)
+		res.msgs = append(res.msgs, fmt.Sprintf("%v %x", sessionFlags(msg.Flags), sum[:8]))
+	}
+	assert.NilError(t, <-done, "ListMessages %s", mbox.Name())
+	return res
+}
+
+func faultContents(state map[string]faultMailbox) map[string][]string {
+	res := make(map[string][]string, len(state))
+	for name, mbox := range state {
+		res[name] = mbox.msgs
+	}
+	return res
+}
+
+func formatFaultContents(contents map[string][]string) string {
+	names := make([]string, 0, len(contents))
+	for name := range contents {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&b, "\n  %s:", name)
+		for _, msg := range contents[name] {
+			fmt.Fprintf(&b, "\n    %s", msg)
+		}
+	}
+	return b.String()
+}
+
+// checkFaultInvariants checks that UIDs are unique and ascending, that
+// UIDNEXT is greater than any UID and that it is not decreased.
+func checkFaultInvariants(t *testing.T, state, before map[string]faultMailbox) {
+	t.Helper()
+
+	for name, mbox := range state {
+		for i := 1; i < len(mbox.uids); i++ {
+			if mbox.uids[i] <= mbox.uids[i-1] {
+				t.Errorf("%s: UIDs are not unique or not ascending: %v", name, mbox.uids)
+				break
+			}
+		}
+		if len(mbox.uids) != 0 && mbox.uids[len(mbox.uids)-1] >= mbox.uidNext {
+			t.Errorf("%s: UIDNEXT %d is not greater than max UID %d", name, mbox.uidNext, mbox.uids[len(mbox.uids)-1])
+		}
+		if prev, ok := before[name]; ok && mbox.uidNext < prev.uidNext {
+			t.Errorf("%s: UIDNEXT decreased from %d to %d", name, prev.uidNext, mbox.uidNext)
+		}
+	}
+}
+
+// faultReference executes operation without faults and returns contents
+// of mailboxes after it.
+func faultReference(t *testing.T, newBack NewBackFunc, closeBack CloseBackFunc, sc faultScenario) map[string][]string {
+	t.Helper()
+
+	b := newBack()
+	defer closeBack(b)
+	u, mbox := setupFaults(t, b)
+	defer u.Logout()
+	if _, ok := mbox.(move.Mailbox); sc.needMove && !ok {
+		t.Skip("Backend doesn't supports MOVE (need move.Mailbox interface)")
+		t.SkipNow()
+	}
+
+	assert.NilError(t, sc.run(u, mbox), "%s without injected faults", sc.name)
+	return faultContents(captureFaults(t, u))
+}
+
+// checkFault executes operation with fault injected by inject, reopens
+// backend and checks that operation is either applied completely or not
+// applied at all. It returns true if operation succeeded.
+func checkFault(t *testing.T, newBack NewBackFunc, closeBack CloseBackFunc, sc faultScenario, after map[string][]string, inject func(FaultInjector)) (succeeded bool) {
+	b := newBack()
+	defer func() {
+		closeBack(b)
+	}()
+	stop := make(chan struct{})
+	defer close(stop)
+	if updater, ok := b.(backend.BackendUpdater); ok {
+		go drainUpdates(updater.Updates(), stop)
+	}
+
+	u, mbox := setupFaults(t, b)
+	before := captureFaults(t, u)
+
+	inject(b.(FaultInjector))
+	var opErr error
+	panicked := true
+	func() {
+		defer func() {
+			if rec := recover(); rec != nil {
+				t.Logf("%s panicked: %v", sc.name, rec)
+			}
+		}()
+		opErr = sc.run(u, mbox)
+		panicked = false
+	}()
+	if opErr != nil {
+		t.Logf("%s failed: %v", sc.name, opErr)
+	}
+
+	reopened, err := b.(FaultInjector).Reopen()
+	assert.NilError(t, err, "Reopen")
+	b = reopened
+	if updater, ok := b.(backend.BackendUpdater); ok {
+		go drainUpdates(updater.Updates(), stop)
+	}
+	u, err = b.GetUser(faultsUser)
+	assert.NilError(t, err, "GetUser after Reopen")
+	defer u.Logout()
+
+	state := captureFaults(t, u)
+	checkFaultInvariants(t, state, before)
+
+	contents := faultContents(state)
+	succeeded = !panicked && opErr == nil
+	switch {
+	case cmp.Equal(contents, after):
+	case succeeded:
+		t.Errorf("%s succeeded, but it is not applied completely after Reopen\nExpected:%s\nGot:%s", sc.name, formatFaultContents(after), formatFaultContents(contents))
+	case cmp.Equal(contents, faultContents(before)):
+		for name, mbox := range before {
+			assert.Check(t, is.DeepEqual(state[name].uids, mbox.uids), "UIDs in %s changed, but operation is not applied", name)
+		}
+	default:
+		t.Errorf("%s is partially applied\nBefore:%s\nAfter:%s\nGot:%s", sc.name, formatFaultContents(faultContents(before)), formatFaultContents(after), formatFaultContents(contents))
+	}
+	return succeeded
+}
+
+// Backend_CrashConsistency injects storage write failures and panics into
+// operations and checks that after Reopen each operation is either applied
+// completely or not applied at all.
+func Backend_CrashConsistency(t *testing.T, newBack NewBackFunc, closeBack CloseBackFunc) {
+	b := newBack()
+	fi, ok := b.(FaultInjector)
+	var points []string
+	if ok {
+		points = fi.PanicPoints()
+	}
+	closeBack(b)
+	if !ok {
+		t.Skip("Backend doesn't supports fault injection (need FaultInjector interface)")
+		t.SkipNow()
+	}
+
+	for _, sc := range faultScenarios {
+		sc := sc
+		t.Run(sc.name, func(t *testing.T) {
+			skipIfExcluded(t)
+
+			after := faultReference(t, newBack, closeBack, sc)
+			for n := 1; n <= faultMaxWrites; n++ {
+				succeeded := false
+				passed := t.Run(fmt.Sprintf("write %d", n), func(t *testing.T) {
+					skipIfExcluded(t)
+					succeeded = checkFault(t, newBack, closeBack, sc, after, func(fi FaultInjector) {
+						fi.FailWrite(n, errInjectedWrite)
+					})
+				})
+				// Operation that succeeded doesn't reach write n, so
+				// there is no need to check following ones.
+				if !passed || succeeded {
+					break
+				}
+			}
+			for _, point := range points {
+				point := point
+				t.Run("panic "+point, func(t *testing.T) {
+					skipIfExcluded(t)
+					checkFault(t, newBack, closeBack, sc, after, func(fi FaultInjector) {
+						fi.PanicAt(point)
+					})
+				})
+			}
+		})
+	}
+}
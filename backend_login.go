@@ -0,0 +1,132 @@
+package backendtests
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/backend"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+// testConnInfo is passed to backend.Backend.Login, it resembles plain-text
+// connection over loopback interface.
+var testConnInfo = &imap.ConnInfo{
+	RemoteAddr: &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 50000},
+	LocalAddr:  &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 143},
+}
+
+// checkInvalidCredentials checks that err returned by Login is (or wraps)
+// backend.ErrInvalidCredentials.
+func checkInvalidCredentials(t *testing.T, err error) {
+	t.Helper()
+	assert.Assert(t, errors.Is(err, backend.ErrInvalidCredentials), "Expected %v error, got %v", backend.ErrInvalidCredentials, err)
+}
+
+func Backend_Login(t *testing.T, newBack NewBackFunc, closeBack CloseBackFunc) {
+	b := newBack()
+	defer closeBack(b)
+
+	loginBack, ok := b.(backend.Backend)
+	if !ok {
+		t.Skip("Backend doesn't implement authentication (need backend.Backend interface)")
+		t.SkipNow()
+	}
+	admin, ok := b.(UserAdminBackend)
+	if !ok {
+		t.Skip("Can't set user password (need UserAdminBackend interface)")
+		t.SkipNow()
+	}
+
+	assert.NilError(t, b.CreateUser("username1"))
+	assert.NilError(t, admin.SetUserPassword("username1", "password1"))
+	assert.NilError(t, b.CreateUser("username2"))
+	assert.NilError(t, admin.SetUserPassword("username2", "password2"))
+
+	t.Run("Correct password", func(t *testing.T) {
+		skipIfExcluded(t)
+
+		u, err := loginBack.Login(testConnInfo, "username1", "password1")
+		assert.NilError(t, err)
+		defer assert.NilError(t, u.Logout())
+		assert.Check(t, is.Equal(u.Username(), "username1"), "Username mismatch")
+	})
+	t.Run("Wrong password", func(t *testing.T) {
+		skipIfExcluded(t)
+
+		_, err := loginBack.Login(testConnInfo, "username1", "password2")
+		checkInvalidCredentials(t, err)
+	})
+	t.Run("Password of another user", func(t *testing.T) {
+		skipIfExcluded(t)
+
+		_, err := loginBack.Login(testConnInfo, "username2", "password1")
+		checkInvalidCredentials(t, err)
+	})
+	t.Run("Unknown user", func(t *testing.T) {
+		skipIfExcluded(t)
+
+		// Backend should not reveal whether user exists.
+		_, err := loginBack.Login(testConnInfo, "username3", "password1")
+		checkInvalidCredentials(t, err)
+	})
+	t.Run("Empty password", func(t *testing.T) {
+		skipIfExcluded(t)
+
+		_, err := loginBack.Login(testConnInfo, "username1", "")
+		checkInvalidCredentials(t, err)
+	})
+	t.Run("No password set", func(t *testing.T) {
+		skipIfExcluded(t)
+
+		assert.NilError(t, b.CreateUser("username3"))
+		_, err := loginBack.Login(testConnInfo, "username3", "")
+		checkInvalidCredentials(t, err)
+	})
+	t.Run("Empty username", func(t *testing.T) {
+		skipIfExcluded(t)
+
+		_, err := loginBack.Login(testConnInfo, "", "password1")
+		checkInvalidCredentials(t, err)
+	})
+	t.Run("Storage failure", func(t *testing.T) {
+		skipIfExcluded(t)
+
+		fi, ok := b.(ReadFaultInjector)
+		if !ok {
+			t.Skip("Backend doesn't supports read fault injection (need ReadFaultInjector interface)")
+			t.SkipNow()
+		}
+
+		// Credentials can't be checked if storage fails, it should not be
+		// reported as wrong credentials.
+		for _, password := range []string{"password1", "password2"} {
+			fi.FailRead(1, errInjectedRead)
+			_, err := loginBack.Login(testConnInfo, "username1", password)
+			fi.FailRead(0, nil)
+			assert.Assert(t, err != nil, "Login succeeded with failed storage read")
+			assert.Check(t, !errors.Is(err, backend.ErrInvalidCredentials), "Storage failure is reported as %v error: %v", backend.ErrInvalidCredentials, err)
+		}
+
+		u, err := loginBack.Login(testConnInfo, "username1", "password1")
+		assert.NilError(t, err, "Login after storage failure")
+		assert.NilError(t, u.Logout())
+	})
+	t.Run("Same as GetUser", func(t *testing.T) {
+		skipIfExcluded(t)
+
+		loginU, err := loginBack.Login(testConnInfo, "username1", "password1")
+		assert.NilError(t, err)
+		defer assert.NilError(t, loginU.Logout())
+		getU, err := b.GetUser("username1")
+		assert.NilError(t, err)
+		defer assert.NilError(t, getU.Logout())
+
+		assert.Check(t, is.Equal(loginU.Username(), getU.Username()), "Username mismatch")
+		assert.Check(t, is.DeepEqual(mailboxNames(t, loginU, false), mailboxNames(t, getU, false)), "Mailboxes list mismatch")
+		checkSameUser(t, loginU, getU, "LOGIN-TEST")
+		checkSameUser(t, getU, loginU, "GETUSER-TEST")
+	})
+}
@@ -0,0 +1,200 @@
+package backendtests
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/backend"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+// checkUserError checks that err matches target. Only backends implementing
+// UserAdminBackend are required to use errors from this package, any non-nil
+// error is accepted from other backends.
+func checkUserError(t *testing.T, b Backend, err, target error) {
+	t.Helper()
+
+	if _, ok := b.(UserAdminBackend); !ok {
+		assert.Assert(t, err != nil, "Expected error, got nil")
+		return
+	}
+	assert.Assert(t, errors.Is(err, target), "Expected %v error, got %v", target, err)
+}
+
+func Backend_CreateUser_Duplicate(t *testing.T, newBack NewBackFunc, closeBack CloseBackFunc) {
+	b := newBack()
+	defer closeBack(b)
+
+	assert.NilError(t, b.CreateUser("username1"))
+	checkUserError(t, b, b.CreateUser("username1"), ErrUserAlreadyExists)
+}
+
+func Backend_GetUser_NonExistent(t *testing.T, newBack NewBackFunc, closeBack CloseBackFunc) {
+	b := newBack()
+	defer closeBack(b)
+
+	_, err := b.GetUser("username1")
+	checkUserError(t, b, err, ErrUserDoesNotExist)
+}
+
+// checkSameUser checks that u1 and u2 refer to the same account by creating
+// mailbox with specified name using one handle and looking it up using
+// another.
+func checkSameUser(t *testing.T, u1, u2 backend.User, mboxName string) {
+	t.Helper()
+
+	assert.NilError(t, u1.CreateMailbox(mboxName))
+	_, err := u2.GetMailbox(mboxName)
+	assert.NilError(t, err, "User handles refer to different accounts")
+}
+
+func Backend_Username_Normalization(t *testing.T, newBack NewBackFunc, closeBack CloseBackFunc) {
+	// Backend is free to either treat equivalent usernames as the same account
+	// or reject them completely. It is not allowed to create separate accounts
+	// for names that are considered equivalent by GetUser.
+	testEquivalent := func(t *testing.T, name, equivName string) {
+		b := newBack()
+		defer closeBack(b)
+		u := getNamedUser(t, b, name)
+		defer assert.NilError(t, u.Logout())
+
+		equivU, err := b.GetUser(equivName)
+		if err != nil {
+			checkUserError(t, b, err, ErrUserDoesNotExist)
+			return
+		}
+		defer assert.NilError(t, equivU.Logout())
+
+		checkSameUser(t, u, equivU, "NORMALIZATION-TEST")
+		checkUserError(t, b, b.CreateUser(equivName), ErrUserAlreadyExists)
+	}
+
+	t.Run("Case", func(t *testing.T) {
+		skipIfExcluded(t)
+		testEquivalent(t, "username1", "USERNAME1")
+	})
+	t.Run("Unicode NFC to NFD", func(t *testing.T) {
+		skipIfExcluded(t)
+		testEquivalent(t, "\u00fcsername", "u\u0308sername")
+	})
+	t.Run("Unicode NFD to NFC", func(t *testing.T) {
+		skipIfExcluded(t)
+		testEquivalent(t, "u\u0308sername", "\u00fcsername")
+	})
+}
+
+func Backend_DeleteUser(t *testing.T, newBack NewBackFunc, closeBack CloseBackFunc) {
+	b := newBack()
+	defer closeBack(b)
+
+	admin, ok := b.(UserAdminBackend)
+	if !ok {
+		t.Skip("User management is not implemented (need UserAdminBackend interface)")
+		t.SkipNow()
+	}
+
+	t.Run("ListUsers", func(t *testing.T) {
+		skipIfExcluded(t)
+
+		assert.NilError(t, b.CreateUser("username1"))
+		assert.NilError(t, b.CreateUser("username2"))
+
+		users, err := admin.ListUsers()
+		assert.NilError(t, err)
+		assert.Check(t, is.Contains(users, "username1"))
+		assert.Check(t, is.Contains(users, "username2"))
+
+		assert.NilError(t, admin.DeleteUser("username1"))
+
+		users, err = admin.ListUsers()
+		assert.NilError(t, err)
+		assert.Check(t, !is.Contains(users, "username1")().Success(), "Deleted user is still listed")
+		assert.Check(t, is.Contains(users, "username2"), "Deletion of one user removed another")
+
+		assert.NilError(t, admin.DeleteUser("username2"))
+	})
+	t.Run("Non-existent", func(t *testing.T) {
+		skipIfExcluded(t)
+
+		assert.Assert(t, errors.Is(admin.DeleteUser("username1"), ErrUserDoesNotExist))
+	})
+	t.Run("Cascade", func(t *testing.T) {
+		skipIfExcluded(t)
+
+		u := getNamedUser(t, b, "username1")
+		mbox := getNamedMbox(t, u, "TEST")
+		createMsgs(t, mbox, 3)
+		assert.NilError(t, mbox.SetSubscribed(true))
+		assert.NilError(t, u.Logout())
+
+		assert.NilError(t, admin.DeleteUser("username1"))
+		_, err := b.GetUser("username1")
+		assert.Assert(t, errors.Is(err, ErrUserDoesNotExist), "Expected %v error, got %v", ErrUserDoesNotExist, err)
+
+		// User with the same name should not get any data of the deleted one.
+		u = getNamedUser(t, b, "username1")
+		defer assert.NilError(t, u.Logout())
+
+		_, err = u.GetMailbox("TEST")
+		assert.Error(t, err, backend.ErrNoSuchMailbox.Error(), "Mailbox of deleted user is still present")
+		assert.Check(t, !is.Contains(mailboxNames(t, u, true), "TEST")().Success(), "Subscription of deleted user is still present")
+
+		inbox, err := u.GetMailbox(imap.InboxName)
+		if err == nil {
+			status, err := inbox.Status([]imap.StatusItem{imap.StatusMessages})
+			assert.NilError(t, err)
+			assert.Check(t, is.Equal(status.Messages, uint32(0)), "INBOX of re-created user is not empty")
+		}
+
+		assert.NilError(t, admin.DeleteUser("username1"))
+	})
+	t.Run("Deleted handle", func(t *testing.T) {
+		skipIfExcluded(t)
+
+		u := getNamedUser(t, b, "username1")
+		mbox := getNamedMbox(t, u, "TEST")
+		assert.NilError(t, admin.DeleteUser("username1"))
+
+		_, err := u.ListMailboxes(false)
+		assert.Check(t, err != nil, "ListMailboxes succeeded for deleted user")
+		_, err = u.GetMailbox("TEST")
+		assert.Check(t, err != nil, "GetMailbox succeeded for deleted user")
+		assert.Check(t, u.CreateMailbox("TEST2") != nil, "CreateMailbox succeeded for deleted user")
+		_, err = mbox.Status([]imap.StatusItem{imap.StatusMessages})
+		assert.Check(t, err != nil, "Status succeeded for mailbox of deleted user")
+
+		// Handle of user created with the same name later should not
+		// make old handle usable.
+		u2 := getNamedUser(t, b, "username1")
+		defer assert.NilError(t, u2.Logout())
+		_, err = u.ListMailboxes(false)
+		assert.Check(t, err != nil, "Handle of deleted user is valid for re-created user")
+	})
+}
+
+func Backend_UserPassword(t *testing.T, newBack NewBackFunc, closeBack CloseBackFunc) {
+	b := newBack()
+	defer closeBack(b)
+
+	admin, ok := b.(UserAdminBackend)
+	if !ok {
+		t.Skip("User management is not implemented (need UserAdminBackend interface)")
+		t.SkipNow()
+	}
+
+	assert.NilError(t, b.CreateUser("username1"))
+	assert.NilError(t, admin.SetUserPassword("username1", "password1"))
+
+	assert.Check(t, admin.CheckPlain("username1", "password1"), "Valid password is rejected")
+	assert.Check(t, !admin.CheckPlain("username1", "password2"), "Invalid password is accepted")
+	assert.Check(t, !admin.CheckPlain("username1", ""), "Empty password is accepted")
+	assert.Check(t, !admin.CheckPlain("username2", "password1"), "Password is accepted for non-existent user")
+
+	assert.NilError(t, admin.SetUserPassword("username1", "password2"))
+	assert.Check(t, !admin.CheckPlain("username1", "password1"), "Old password is accepted after change")
+	assert.Check(t, admin.CheckPlain("username1", "password2"), "New password is rejected")
+
+	assert.Assert(t, errors.Is(admin.SetUserPassword("username2", "password1"), ErrUserDoesNotExist))
+}
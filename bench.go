@@ -0,0 +1,279 @@
+package backendtests
+
+import (
+	"io"
+	"io/ioutil"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap"
+	move "github.com/emersion/go-imap-move"
+	"github.com/emersion/go-imap/backend"
+	"gotest.tools/assert"
+)
+
+type benchFunc func(*testing.B, NewBackFunc, CloseBackFunc)
+
+const (
+	// benchMsgs is the amount of messages in mailbox used by ListMessages
+	// and SearchMessages benchmarks.
+	benchMsgs = 100
+
+	// benchBatch is the amount of messages copied, moved or expunged by
+	// single operation.
+	benchBatch = 10
+
+	// benchLargeSize is the size of message used by CreateMessage/large.
+	benchLargeSize = 1024 * 1024
+)
+
+// RunBenchmarks runs all benchmarks against backend created using passed
+// callback functions. Whitelist and Blacklist are applied to benchmarks too.
+func RunBenchmarks(b *testing.B, newBackend NewBackFunc, closeBackend CloseBackFunc) {
+	addBench := func(f benchFunc) {
+		b.Run(getFunctionName(f), func(b *testing.B) {
+			skipIfExcluded(b)
+			b.ReportAllocs()
+			f(b, newBackend, closeBackend)
+		})
+	}
+
+	addBench(Bench_CreateMessage)
+	addBench(Bench_ListMessages)
+	addBench(Bench_SearchMessages)
+	addBench(Bench_UpdateMessagesFlags)
+	addBench(Bench_CopyMessages)
+	addBench(Bench_MoveMessages)
+	addBench(Bench_Expunge)
+}
+
+// newBenchBackend creates backend and discards all updates sent by it.
+// Returned function should be called instead of closeBack.
+func newBenchBackend(newBack NewBackFunc, closeBack CloseBackFunc) (Backend, func()) {
+	bk := newBack()
+	stop := make(chan struct{})
+	if updater, ok := bk.(backend.BackendUpdater); ok {
+		go drainUpdates(updater.Updates(), stop)
+	}
+	return bk, func() {
+		close(stop)
+		closeBack(bk)
+	}
+}
+
+// benchMbox creates mailbox with benchMsgs messages.
+func benchMbox(b *testing.B, bk Backend) (backend.User, backend.Mailbox) {
+	b.Helper()
+
+	u := getUser(b, bk)
+	mbox := getMbox(b, u)
+	for i := 0; i < benchMsgs; i++ {
+		assert.NilError(b, mbox.CreateMessage([]string{}, time.Now(), strings.NewReader(testMailString)))
+	}
+	return u, mbox
+}
+
+// createBatch creates benchBatch messages with specified flags, timer is
+// stopped while messages are created.
+func createBatch(b *testing.B, mbox backend.Mailbox, flags []string) {
+	b.Helper()
+
+	b.StopTimer()
+	defer b.StartTimer()
+	for i := 0; i < benchBatch; i++ {
+		assert.NilError(b, mbox.CreateMessage(flags, time.Now(), strings.NewReader(testMailString)))
+	}
+}
+
+// recreateMbox replaces mailbox with empty one to keep its size bounded,
+// timer is stopped while mailbox is recreated.
+func recreateMbox(b *testing.B, u backend.User, mbox backend.Mailbox) backend.Mailbox {
+	b.Helper()
+
+	b.StopTimer()
+	defer b.StartTimer()
+	assert.NilError(b, u.DeleteMailbox(mbox.Name()))
+	return getNamedMbox(b, u, mbox.Name())
+}
+
+func Bench_CreateMessage(b *testing.B, newBack NewBackFunc, closeBack CloseBackFunc) {
+	bk, closeBench := newBenchBackend(newBack, closeBack)
+	defer closeBench()
+	u := getUser(b, bk)
+	defer assert.NilError(b, u.Logout())
+
+	large := testHeaderFromToString + strings.Repeat("Large message body line.\r\n", benchLargeSize/26)
+
+	for _, body := range []struct {
+		name string
+		text string
+	}{
+		{"small", testMailString},
+		{"large", large},
+	} {
+		body := body
+		b.Run(body.name, func(b *testing.B) {
+			skipIfExcluded(b)
+			b.ReportAllocs()
+
+			mbox := getMbox(b, u)
+			b.SetBytes(int64(len(body.text)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				assert.NilError(b, mbox.CreateMessage([]string{}, time.Now(), strings.NewReader(body.text)))
+			}
+		})
+	}
+}
+
+func Bench_ListMessages(b *testing.B, newBack NewBackFunc, closeBack CloseBackFunc) {
+	bk, closeBench := newBenchBackend(newBack, closeBack)
+	defer closeBench()
+	u, mbox := benchMbox(b, bk)
+	defer assert.NilError(b, u.Logout())
+
+	seq, _ := imap.ParseSeqSet("1:*")
+	for _, set := range []struct {
+		name  string
+		items []imap.FetchItem
+		bytes int64
+	}{
+		{"FLAGS UID", []imap.FetchItem{imap.FetchFlags, imap.FetchUid}, 0},
+		{"ENVELOPE", []imap.FetchItem{imap.FetchEnvelope}, 0},
+		{"BODYSTRUCTURE", []imap.FetchItem{imap.FetchBodyStructure}, 0},
+		{"BODY[]", []imap.FetchItem{"BODY.PEEK[]"}, int64(benchMsgs * len(testMailString))},
+	} {
+		set := set
+		b.Run(set.name, func(b *testing.B) {
+			skipIfExcluded(b)
+			b.ReportAllocs()
+
+			b.SetBytes(set.bytes)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				ch := make(chan *imap.Message, benchMsgs)
+				done := make(chan error, 1)
+				go func() {
+					done <- mbox.ListMessages(false, seq, set.items, ch)
+				}()
+				for msg := range ch {
+					// Literals are read since backend may return
+					// them without reading message from storage.
+					for _, lit := range msg.Body {
+						if lit != nil {
+							_, err := io.Copy(ioutil.Discard, lit)
+							assert.NilError(b, err)
+						}
+					}
+				}
+				assert.NilError(b, <-done)
+			}
+		})
+	}
+}
+
+func Bench_SearchMessages(b *testing.B, newBack NewBackFunc, closeBack CloseBackFunc) {
+	bk, closeBench := newBenchBackend(newBack, closeBack)
+	defer closeBench()
+	u, mbox := benchMbox(b, bk)
+	defer assert.NilError(b, u.Logout())
+
+	for _, search := range []struct {
+		name     string
+		criteria *imap.SearchCriteria
+	}{
+		{"header", &imap.SearchCriteria{Header: textproto.MIMEHeader{"From": {"Mitsuha"}}}},
+		{"body", &imap.SearchCriteria{Body: []string{"this text is not in messages"}}},
+		{"flags", &imap.SearchCriteria{WithoutFlags: []string{imap.SeenFlag}}},
+	} {
+		search := search
+		b.Run(search.name, func(b *testing.B) {
+			skipIfExcluded(b)
+			b.ReportAllocs()
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				_, err := mbox.SearchMessages(false, search.criteria)
+				assert.NilError(b, err)
+			}
+		})
+	}
+}
+
+func Bench_UpdateMessagesFlags(b *testing.B, newBack NewBackFunc, closeBack CloseBackFunc) {
+	bk, closeBench := newBenchBackend(newBack, closeBack)
+	defer closeBench()
+	u, mbox := benchMbox(b, bk)
+	defer assert.NilError(b, u.Logout())
+
+	seq, _ := imap.ParseSeqSet("1:*")
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		// Flags are changed by each operation.
+		op := imap.FlagsOp(imap.AddFlags)
+		if i%2 == 1 {
+			op = imap.RemoveFlags
+		}
+		assert.NilError(b, mbox.UpdateMessagesFlags(false, seq, op, []string{imap.FlaggedFlag}))
+	}
+}
+
+func Bench_CopyMessages(b *testing.B, newBack NewBackFunc, closeBack CloseBackFunc) {
+	bk, closeBench := newBenchBackend(newBack, closeBack)
+	defer closeBench()
+	u, mbox := benchMbox(b, bk)
+	defer assert.NilError(b, u.Logout())
+	tgt := getMbox(b, u)
+
+	seq := new(imap.SeqSet)
+	seq.AddRange(1, benchBatch)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if i != 0 && i%(benchMsgs/benchBatch) == 0 {
+			tgt = recreateMbox(b, u, tgt)
+		}
+		assert.NilError(b, mbox.CopyMessages(false, seq, tgt.Name()))
+	}
+}
+
+func Bench_MoveMessages(b *testing.B, newBack NewBackFunc, closeBack CloseBackFunc) {
+	bk, closeBench := newBenchBackend(newBack, closeBack)
+	defer closeBench()
+	u, mbox := benchMbox(b, bk)
+	defer assert.NilError(b, u.Logout())
+	tgt := getMbox(b, u)
+
+	moveMbox, ok := mbox.(move.Mailbox)
+	if !ok {
+		b.Skip("Backend doesn't supports MOVE (need move.Mailbox interface)")
+		b.SkipNow()
+	}
+
+	// Moved messages are the last ones so sequence numbers of the rest are
+	// not changed.
+	seq := new(imap.SeqSet)
+	seq.AddRange(benchMsgs+1, benchMsgs+benchBatch)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if i != 0 && i%(benchMsgs/benchBatch) == 0 {
+			tgt = recreateMbox(b, u, tgt)
+		}
+		createBatch(b, mbox, []string{})
+		assert.NilError(b, moveMbox.MoveMessages(false, seq, tgt.Name()))
+	}
+}
+
+func Bench_Expunge(b *testing.B, newBack NewBackFunc, closeBack CloseBackFunc) {
+	bk, closeBench := newBenchBackend(newBack, closeBack)
+	defer closeBench()
+	u, mbox := benchMbox(b, bk)
+	defer assert.NilError(b, u.Logout())
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		createBatch(b, mbox, []string{imap.DeletedFlag})
+		assert.NilError(b, mbox.Expunge())
+	}
+}
@@ -0,0 +1,147 @@
+// Command imap-conformance runs go-imap-backend-tests against IMAP server
+// accessed over network.
+//
+// All operations are executed using go-imap client, so any IMAP server can be
+// tested, not only ones using go-imap. Tests need one or more existing
+// accounts, all data in these accounts is REMOVED, so -destructive flag
+// should be passed to confirm that.
+//
+// Usage:
+//
+//	imap-conformance -addr 127.0.0.1:143 -account user1:pass1 -account user2:pass2 -destructive
+//	imap-conformance -serve-memory
+//
+// -serve-memory runs tests against go-imap memory backend served on loopback
+// interface in-process, it is useful to check the command itself.
+//
+// Flags of testing package (e.g. -test.v) can be used too.
+//
+// Exit code is 1 if any test failed.
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+
+	backendtests "github.com/foxcpp/go-imap-backend-tests"
+)
+
+type accountsFlag []backendtests.RemoteAccount
+
+func (f *accountsFlag) String() string {
+	names := make([]string, 0, len(*f))
+	for _, acct := range *f {
+		names = append(names, acct.Username)
+	}
+	return strings.Join(names, ",")
+}
+
+func (f *accountsFlag) Set(value string) error {
+	parts := strings.SplitN(value, ":", 2)
+	if len(parts) != 2 {
+		return errors.New("account should be specified as username:password")
+	}
+	*f = append(*f, backendtests.RemoteAccount{Username: parts[0], Password: parts[1]})
+	return nil
+}
+
+func splitList(value string) []string {
+	if value == "" {
+		return nil
+	}
+	return strings.Split(value, ",")
+}
+
+func main() {
+	testing.Init()
+
+	var (
+		addr        = flag.String("addr", "", "IMAP server address (host:port)")
+		useTLS      = flag.Bool("tls", false, "use implicit TLS")
+		insecure    = flag.Bool("tls-insecure", false, "do not verify server certificate")
+		destructive = flag.Bool("destructive", false, "confirm that all data in accounts can be removed")
+		serveMem    = flag.Bool("serve-memory", false, "test go-imap memory backend served in-process")
+		run         = flag.String("run", "", "comma-separated list of test name prefixes to run")
+		skip        = flag.String("skip", "", "comma-separated list of test name prefixes to skip")
+		timeoutScl  = flag.Float64("timeout-scale", 1, "multiplier for all timeouts, increase for slow servers")
+		scale       = flag.Int("scale", 0, "amount of messages for scale tests (disabled if 0)")
+		accounts    accountsFlag
+	)
+	flag.Var(&accounts, "account", "username:password of account to use (can be repeated)")
+	flag.Parse()
+
+	log.SetFlags(0)
+	log.SetPrefix("imap-conformance: ")
+
+	var tlsConfig *tls.Config
+	if *serveMem {
+		_, l, err := serveMemory()
+		if err != nil {
+			log.Fatalln(err)
+		}
+
+		*addr = l.Addr().String()
+		accounts = accountsFlag{
+			{Username: "username1", Password: memoryPassword},
+			{Username: "username2", Password: memoryPassword},
+		}
+	} else {
+		if *addr == "" || len(accounts) == 0 {
+			log.Fatalln("-addr and at least one -account are required")
+		}
+		if !*destructive {
+			log.Fatalln("all data in tested accounts will be removed, pass -destructive to confirm")
+		}
+		if *useTLS {
+			tlsConfig = &tls.Config{InsecureSkipVerify: *insecure}
+		}
+	}
+
+	backendtests.Whitelist = splitList(*run)
+	backendtests.Blacklist = splitList(*skip)
+	for _, test := range backendtests.WireExcluded {
+		backendtests.Blacklist = append(backendtests.Blacklist, "Conformance/"+test)
+	}
+	backendtests.Report = &backendtests.TestReport{}
+	backendtests.TimeoutScale = *timeoutScl
+	backendtests.ScaleMessages = *scale
+
+	newBack := func() backendtests.Backend {
+		rb := &backendtests.RemoteBackend{
+			Addr:      *addr,
+			TLSConfig: tlsConfig,
+			Accounts:  accounts,
+		}
+		if err := rb.Reset(); err != nil {
+			// CreateUser and GetUser return the error too, so only the
+			// current test fails instead of the whole run.
+			log.Println(err)
+		}
+		return rb
+	}
+	closeBack := func(b backendtests.Backend) {
+		b.(*backendtests.RemoteBackend).Close()
+	}
+
+	// testing.Main exits with code 1 if any test failed.
+	testing.Main(regexp.MatchString, []testing.InternalTest{
+		{
+			Name: "Conformance",
+			F: func(t *testing.T) {
+				backendtests.RunTests(t, newBack, closeBack)
+
+				fmt.Println()
+				if _, err := backendtests.Report.WriteTo(os.Stdout); err != nil {
+					t.Error(err)
+				}
+			},
+		},
+	}, nil, nil)
+}
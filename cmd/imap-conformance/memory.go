@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net"
+	"sync"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/backend"
+	"github.com/emersion/go-imap/backend/memory"
+	"github.com/emersion/go-imap/server"
+)
+
+const memoryPassword = "password"
+
+// memoryBackend serves separate go-imap memory backend instance for each
+// username, memory backend itself has only one user.
+type memoryBackend struct {
+	lock  sync.Mutex
+	users map[string]*memory.Backend
+}
+
+func (b *memoryBackend) Login(connInfo *imap.ConnInfo, username, password string) (backend.User, error) {
+	if password != memoryPassword {
+		return nil, backend.ErrInvalidCredentials
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	be, ok := b.users[username]
+	if !ok {
+		be = memory.New()
+		b.users[username] = be
+	}
+	return be.Login(connInfo, "username", "password")
+}
+
+// serveMemory starts IMAP server with memory backend on loopback interface.
+// Any username can be used with memoryPassword.
+func serveMemory() (*server.Server, net.Listener, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	srv := server.New(&memoryBackend{users: make(map[string]*memory.Backend)})
+	srv.AllowInsecureAuth = true
+	go srv.Serve(l)
+
+	return srv, l, nil
+}
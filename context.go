@@ -0,0 +1,40 @@
+package backendtests
+
+import (
+	"context"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/backend"
+)
+
+// ListContextMailbox is extension for backend.Mailbox interface which allows
+// to cancel ListMessages.
+type ListContextMailbox interface {
+	backend.Mailbox
+
+	// ListMessagesContext is ListMessages that stops sending messages and
+	// returns ctx.Err() when ctx is cancelled. ch should be closed in
+	// any case.
+	ListMessagesContext(ctx context.Context, uid bool, seqset *imap.SeqSet, items []imap.FetchItem, ch chan<- *imap.Message) error
+}
+
+// SearchContextMailbox is extension for backend.Mailbox interface which
+// allows to cancel SearchMessages.
+type SearchContextMailbox interface {
+	backend.Mailbox
+
+	// SearchMessagesContext is SearchMessages that returns ctx.Err() when
+	// ctx is cancelled.
+	SearchMessagesContext(ctx context.Context, uid bool, criteria *imap.SearchCriteria) ([]uint32, error)
+}
+
+// CreateContextMailbox is extension for backend.Mailbox interface which
+// allows to cancel CreateMessage.
+type CreateContextMailbox interface {
+	backend.Mailbox
+
+	// CreateMessageContext is CreateMessage that returns ctx.Err() when
+	// ctx is cancelled. Message should not be created in this case.
+	CreateMessageContext(ctx context.Context, flags []string, date time.Time, body imap.Literal) error
+}
@@ -0,0 +1,170 @@
+// Package corpus provides a collection of real-world and malformed messages
+// with expected results of parsing them.
+//
+// Each message is stored in messages directory as NAME.eml together with
+// NAME.json containing expected ENVELOPE, BODYSTRUCTURE and contents of body
+// sections. Expected results are computed using go-imap backendutil package,
+// run "go generate" after adding messages or updating go-imap. BODYSTRUCTURE
+// fields backendutil doesn't compute are added by hand for some messages,
+// they are kept by "go generate".
+package corpus
+
+import (
+	"bytes"
+	"embed"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/emersion/go-imap"
+)
+
+//go:generate go run ./gen -update
+
+//go:embed messages
+var files embed.FS
+
+// Message is a message from the corpus.
+type Message struct {
+	Name string
+	// Raw is the message as it should be passed to CreateMessage.
+	Raw []byte
+
+	Envelope      *imap.Envelope
+	BodyStructure *imap.BodyStructure
+	// Sections maps section specification used in BODY[] (e.g. "HEADER",
+	// "1.2" or "2.MIME") to its contents. Empty key is the entire message.
+	Sections map[string][]byte
+}
+
+// SectionNames returns names of all sections in Sections in sorted order.
+func (m *Message) SectionNames() []string {
+	names := make([]string, 0, len(m.Sections))
+	for name := range m.Sections {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// sectionBody is encoded as JSON string if it is valid UTF-8, otherwise it is
+// encoded as {"base64": "..."}.
+type sectionBody []byte
+
+type base64Body struct {
+	Base64 string `json:"base64"`
+}
+
+func (b sectionBody) MarshalJSON() ([]byte, error) {
+	if utf8.Valid(b) {
+		return json.Marshal(string(b))
+	}
+	return json.Marshal(base64Body{base64.StdEncoding.EncodeToString(b)})
+}
+
+func (b *sectionBody) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*b = sectionBody(s)
+		return nil
+	}
+
+	var enc base64Body
+	if err := json.Unmarshal(data, &enc); err != nil {
+		return err
+	}
+	raw, err := base64.StdEncoding.DecodeString(enc.Base64)
+	if err != nil {
+		return err
+	}
+	*b = raw
+	return nil
+}
+
+// expected is the format of NAME.json files.
+type expected struct {
+	Envelope      *imap.Envelope
+	BodyStructure *imap.BodyStructure
+	Sections      map[string]sectionBody
+}
+
+// MarshalExpected returns contents of NAME.json file for message m.
+func MarshalExpected(m *Message) ([]byte, error) {
+	exp := expected{
+		Envelope:      m.Envelope,
+		BodyStructure: m.BodyStructure,
+		Sections:      make(map[string]sectionBody, len(m.Sections)),
+	}
+	for name, body := range m.Sections {
+		exp.Sections[name] = body
+	}
+
+	// Encoder is used to keep "<" and ">" in Message-ID and Content-ID
+	// readable.
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "\t")
+	if err := enc.Encode(exp); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Messages returns all messages from the corpus sorted by name.
+func Messages() ([]Message, error) {
+	entries, err := files.ReadDir("messages")
+	if err != nil {
+		return nil, err
+	}
+
+	var msgs []Message
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), ".eml") {
+			continue
+		}
+		msg, err := Load(strings.TrimSuffix(entry.Name(), ".eml"))
+		if err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, msg)
+	}
+	if len(msgs) == 0 {
+		return nil, errors.New("corpus: no messages")
+	}
+	return msgs, nil
+}
+
+// Load returns message with specified name (NAME.eml file without
+// extension).
+func Load(name string) (Message, error) {
+	msg := Message{Name: name}
+
+	var err error
+	msg.Raw, err = files.ReadFile(path.Join("messages", name+".eml"))
+	if err != nil {
+		return msg, err
+	}
+
+	data, err := files.ReadFile(path.Join("messages", name+".json"))
+	if err != nil {
+		return msg, err
+	}
+	var exp expected
+	if err := json.Unmarshal(data, &exp); err != nil {
+		return msg, fmt.Errorf("corpus: %s.json: %v", name, err)
+	}
+
+	msg.Envelope = exp.Envelope
+	msg.BodyStructure = exp.BodyStructure
+	msg.Sections = make(map[string][]byte, len(exp.Sections))
+	for name, body := range exp.Sections {
+		msg.Sections[name] = body
+	}
+	return msg, nil
+}
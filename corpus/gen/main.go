@@ -0,0 +1,184 @@
+// Command gen checks that expected results stored in corpus match results of
+// go-imap backendutil package and regenerates them if -update is passed.
+// BODYSTRUCTURE fields backendutil doesn't compute are kept from existing
+// expected results, they are added by hand.
+//
+// Usage (from corpus directory):
+//
+//	go run ./gen [-update] [messages directory]
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/emersion/go-imap"
+	"github.com/foxcpp/go-imap-backend-tests/corpus"
+)
+
+// partNames appends names of all parts of bs and their MIME headers to names.
+func partNames(prefix string, bs *imap.BodyStructure, names []string) []string {
+	for i, part := range bs.Parts {
+		name := strconv.Itoa(i + 1)
+		if prefix != "" {
+			name = prefix + "." + name
+		}
+		names = append(names, name, name+".MIME")
+		names = partNames(name, part, names)
+	}
+	return names
+}
+
+// reference returns message with expected results computed by backendutil.
+func reference(name string, raw []byte) (*corpus.Message, error) {
+	msg := &corpus.Message{Name: name, Raw: raw, Sections: map[string][]byte{}}
+
+	var err error
+	msg.Envelope, err = corpus.ReferenceEnvelope(raw)
+	if err != nil {
+		return nil, fmt.Errorf("envelope: %v", err)
+	}
+
+	names := []string{"", "HEADER", "TEXT"}
+	msg.BodyStructure, err = corpus.ReferenceBodyStructure(raw)
+	if err != nil {
+		// Body structure of malformed message is not defined.
+		log.Printf("%s: body structure is not stored: %v", name, err)
+		msg.BodyStructure = nil
+	} else {
+		names = partNames("", msg.BodyStructure, names)
+	}
+
+	for _, sectName := range names {
+		section, err := imap.ParseBodySectionName(imap.FetchItem("BODY.PEEK[" + sectName + "]"))
+		if err != nil {
+			return nil, err
+		}
+		body, err := corpus.ReferenceSection(raw, section)
+		if err != nil {
+			log.Printf("%s: BODY[%s] is not stored: %v", name, sectName, err)
+			continue
+		}
+		msg.Sections[sectName] = body
+	}
+	return msg, nil
+}
+
+// keepVerified copies BODYSTRUCTURE fields backendutil doesn't compute from
+// old contents of NAME.json to msg. These fields are added by hand for
+// messages that are used to check them.
+func keepVerified(msg *corpus.Message, old []byte) error {
+	if old == nil || msg.BodyStructure == nil {
+		return nil
+	}
+	var exp struct {
+		BodyStructure *imap.BodyStructure
+	}
+	if err := json.Unmarshal(old, &exp); err != nil {
+		return err
+	}
+	keepVerifiedFields(msg.BodyStructure, exp.BodyStructure)
+	return nil
+}
+
+func keepVerifiedFields(bs, old *imap.BodyStructure) {
+	if old == nil {
+		return
+	}
+	if bs.Encoding == "" {
+		bs.Encoding = old.Encoding
+	}
+	if bs.Size == 0 {
+		bs.Size = old.Size
+	}
+	if bs.Lines == 0 {
+		bs.Lines = old.Lines
+	}
+	if bs.MD5 == "" {
+		bs.MD5 = old.MD5
+	}
+	if bs.Language == nil {
+		bs.Language = old.Language
+	}
+	if bs.Location == nil {
+		bs.Location = old.Location
+	}
+	if bs.Envelope == nil {
+		bs.Envelope = old.Envelope
+	}
+	if bs.BodyStructure == nil {
+		bs.BodyStructure = old.BodyStructure
+	}
+	if len(bs.Parts) != len(old.Parts) {
+		return
+	}
+	for i, part := range bs.Parts {
+		keepVerifiedFields(part, old.Parts[i])
+	}
+}
+
+func main() {
+	update := flag.Bool("update", false, "overwrite expected results instead of checking them")
+	flag.Parse()
+
+	dir := "messages"
+	if flag.NArg() != 0 {
+		dir = flag.Arg(0)
+	}
+
+	emls, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if len(emls) == 0 {
+		log.Fatalln("no messages in", dir)
+	}
+
+	outdated := 0
+	for _, path := range emls {
+		name := strings.TrimSuffix(filepath.Base(path), ".eml")
+		raw, err := ioutil.ReadFile(path)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		msg, err := reference(name, raw)
+		if err != nil {
+			log.Fatalf("%s: %v", name, err)
+		}
+
+		jsonPath := strings.TrimSuffix(path, ".eml") + ".json"
+		old, err := ioutil.ReadFile(jsonPath)
+		if err != nil && !os.IsNotExist(err) {
+			log.Fatalln(err)
+		}
+		if err := keepVerified(msg, old); err != nil {
+			log.Fatalf("%s: %v", name, err)
+		}
+
+		data, err := corpus.MarshalExpected(msg)
+		if err != nil {
+			log.Fatalf("%s: %v", name, err)
+		}
+		if *update {
+			if err := ioutil.WriteFile(jsonPath, data, 0644); err != nil {
+				log.Fatalln(err)
+			}
+			continue
+		}
+		if !bytes.Equal(old, data) {
+			log.Printf("%s: expected results are outdated", name)
+			outdated++
+		}
+	}
+	if outdated != 0 {
+		log.Fatalf("%d messages are outdated, run with -update to regenerate", outdated)
+	}
+}
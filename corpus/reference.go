@@ -0,0 +1,64 @@
+package corpus
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/backend/backendutil"
+	"github.com/emersion/go-message/textproto"
+)
+
+// Reference results are computed using go-imap backendutil package, they are
+// used to generate expected results stored in corpus and by differential
+// tests that check arbitrary messages.
+
+func referenceReader(raw []byte) (textproto.Header, *bufio.Reader, error) {
+	br := bufio.NewReader(bytes.NewReader(raw))
+	hdr, err := textproto.ReadHeader(br)
+	return hdr, br, err
+}
+
+// ReferenceEnvelope returns ENVELOPE of raw message.
+func ReferenceEnvelope(raw []byte) (*imap.Envelope, error) {
+	hdr, _, err := referenceReader(raw)
+	if err != nil {
+		return nil, err
+	}
+	env, err := backendutil.FetchEnvelope(hdr)
+	if err != nil {
+		return nil, err
+	}
+	// RFC 3501 requires server to use From if Sender or Reply-To is
+	// missing, backendutil leaves them empty.
+	if len(env.Sender) == 0 {
+		env.Sender = env.From
+	}
+	if len(env.ReplyTo) == 0 {
+		env.ReplyTo = env.From
+	}
+	return env, nil
+}
+
+// ReferenceBodyStructure returns extended BODYSTRUCTURE of raw message.
+func ReferenceBodyStructure(raw []byte) (*imap.BodyStructure, error) {
+	hdr, br, err := referenceReader(raw)
+	if err != nil {
+		return nil, err
+	}
+	return backendutil.FetchBodyStructure(hdr, br, true)
+}
+
+// ReferenceSection returns body section of raw message.
+func ReferenceSection(raw []byte, section *imap.BodySectionName) ([]byte, error) {
+	hdr, br, err := referenceReader(raw)
+	if err != nil {
+		return nil, err
+	}
+	lit, err := backendutil.FetchBodySection(hdr, br, section)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(lit)
+}
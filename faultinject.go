@@ -0,0 +1,46 @@
+package backendtests
+
+// FaultInjector is extension for Backend interface which allows to simulate
+// storage failures and crashes in the middle of operations.
+//
+// Backends implementing it are expected to keep persistent data consistent
+// after such failures: operation should be either applied completely or not
+// applied at all.
+type FaultInjector interface {
+	Backend
+
+	// FailWrite makes n-th (counting from 1) storage write done after the
+	// call fail with err. What is a write is backend-specific (e.g. SQL
+	// statement or file written). n = 0 disables failure.
+	FailWrite(n int, err error)
+
+	// PanicPoints returns names of points in backend code that are accepted
+	// by PanicAt.
+	PanicPoints() []string
+
+	// PanicAt makes backend panic when execution reaches named point, it
+	// is used to simulate crash of server process. Empty name disables
+	// panic.
+	PanicAt(point string)
+
+	// Reopen simulates restart of server process. It should drop in-memory
+	// state of backend (that may be inconsistent after panic) and return
+	// new Backend object that uses the same persistent storage. Injected
+	// faults are not kept.
+	//
+	// Backend object Reopen is called on is not used after that and is not
+	// passed to CloseBackFunc, returned object is.
+	Reopen() (Backend, error)
+}
+
+// ReadFaultInjector is extension for Backend interface which allows to
+// simulate storage read failures. It is used to check that failures are not
+// reported as wrong credentials by Login.
+type ReadFaultInjector interface {
+	Backend
+
+	// FailRead makes n-th (counting from 1) storage read done after the call
+	// fail with err. What is a read is backend-specific. n = 0 disables
+	// failure.
+	FailRead(n int, err error)
+}
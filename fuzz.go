@@ -0,0 +1,54 @@
+//go:build go1.18
+// +build go1.18
+
+package backendtests
+
+import (
+	"testing"
+)
+
+// fuzzSeeds are added to the seed corpus of FuzzBackend, each one is
+// described by operations it is decoded to.
+var fuzzSeeds = [][]byte{
+	// APPEND INBOX (\Seen) corpus message, FETCH INBOX 1 BODY.PEEK[1],
+	// STORE INBOX 1 +FLAGS (\Deleted), EXPUNGE INBOX.
+	{3, 0, 0x01, 5, 0, 7, 0, 0, 0, 3, 1, 4, 0, 0, 0, 0, 1, 0x08, 6, 0},
+	// CREATE Fuzz1, APPEND Fuzz1 () corpus message, COPY Fuzz1 1 to INBOX,
+	// RENAME Fuzz1 Fuzz2, DELETE Fuzz2.
+	{0, 0, 3, 1, 0, 20, 0, 5, 1, 0, 0, 0, 1, 0, 1, 2, 1},
+	// APPEND INBOX () raw bytes, FETCH INBOX 1 BODY.PEEK[HEADER]<0.10>.
+	{3, 0, 0, 0x80, 12, 'S', 'u', 'b', 'j', 'e', 'c', 't', ':', ' ', 'x', '\r', '\n', 7, 0, 0, 0, 1, 0, 0, 9},
+	// APPEND INBOX () corpus message with replaced bytes, STORE INBOX UID
+	// 1 (\Flagged $A), FETCH INBOX 1 BODY.PEEK[TEXT].
+	{3, 0, 0, 30, 2, 0, 10, ':', 0, 50, '-', 4, 0, 1, 0, 0, 0, 0x12, 7, 0, 0, 0, 2, 1},
+}
+
+// FuzzBackend executes sequences of operations decoded from fuzzed byte
+// streams against backend and reference model: mailboxes are created,
+// renamed and deleted, messages (from corpus with fuzzed bytes or fuzzed
+// completely) are created, copied and expunged, flags are changed and body
+// sections are fetched. Failure is reported if backend state diverges from
+// model or backend panics, the operation trace is minimized before it is
+// printed.
+//
+// Call it from fuzz target of backend test package:
+//
+//	func FuzzBackend(f *testing.F) {
+//		backendtests.FuzzBackend(f, initBackend, cleanBackend)
+//	}
+//
+// and run it with go test -fuzz FuzzBackend.
+func FuzzBackend(f *testing.F, newBackend NewBackFunc, closeBackend CloseBackFunc) {
+	for _, seed := range fuzzSeeds {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		ops := decodeFuzzOps(data)
+		trace, err := runFuzzOps(newBackend, closeBackend, ops)
+		if err == nil {
+			return
+		}
+		_, trace, err = minimizeFuzzOps(newBackend, closeBackend, ops, trace, err)
+		t.Fatalf("%v\nMinimized operations:\n%s", err, formatFuzzTrace(trace))
+	})
+}
@@ -0,0 +1,489 @@
+package backendtests
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/backend"
+	"github.com/foxcpp/go-imap-backend-tests/corpus"
+)
+
+// Fuzzed operations are decoded from byte stream, executed against backend
+// and against reference model, and state of backend is compared with model
+// after each operation. See FuzzBackend.
+
+const (
+	// fuzzMaxOps is the maximum amount of operations decoded from a single
+	// byte stream.
+	fuzzMaxOps = 64
+
+	fuzzUser = "fuzz"
+)
+
+// fuzzMailboxes are mailboxes used by operations. INBOX always exists, it is
+// never created, renamed or deleted.
+var fuzzMailboxes = []string{"INBOX", "Fuzz1", "Fuzz2", "Fuzz3"}
+
+// fuzzSections are body sections requested by ListMessages, partial range
+// may be added to them.
+var fuzzSections = []string{
+	"", "HEADER", "TEXT", "1", "1.MIME", "2", "2.MIME", "1.1", "1.2.MIME", "3",
+	"HEADER.FIELDS (From Subject)", "HEADER.FIELDS.NOT (From Content-Type)",
+	"HEADER.FIELDS (X-Not-Present)",
+}
+
+type fuzzOpKind byte
+
+const (
+	fuzzCreateMailbox fuzzOpKind = iota
+	fuzzRenameMailbox
+	fuzzDeleteMailbox
+	fuzzCreateMessage
+	fuzzUpdateFlags
+	fuzzCopy
+	fuzzExpunge
+	fuzzList
+
+	fuzzOpKinds
+)
+
+// fuzzOp is a single operation. Messages are selected using first and last
+// that are converted to sequence numbers of existing messages when operation
+// is executed.
+type fuzzOp struct {
+	kind   fuzzOpKind
+	mbox   string
+	target string
+
+	uid         bool
+	first, last byte
+	flagsOp     imap.FlagsOp
+	flags       []string
+
+	body []byte
+	// bodyDesc describes how body is created.
+	bodyDesc string
+	// fuzzed is true if body is not taken from corpus as is, backend may
+	// reject such messages.
+	fuzzed bool
+
+	item imap.FetchItem
+}
+
+// fuzzReader returns bytes from stream, 0 is returned after the end.
+type fuzzReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *fuzzReader) byte() byte {
+	if r.pos >= len(r.data) {
+		return 0
+	}
+	b := r.data[r.pos]
+	r.pos++
+	return b
+}
+
+func (r *fuzzReader) done() bool {
+	return r.pos >= len(r.data)
+}
+
+func (r *fuzzReader) mailbox(withInbox bool) string {
+	if withInbox {
+		return fuzzMailboxes[int(r.byte())%len(fuzzMailboxes)]
+	}
+	return fuzzMailboxes[1+int(r.byte())%(len(fuzzMailboxes)-1)]
+}
+
+// flags decodes flags from opFlags, each one corresponds to a bit of byte.
+func (r *fuzzReader) flags() []string {
+	mask := r.byte()
+	flags := []string{}
+	for i, flag := range opFlags {
+		if mask&(1<<uint(i)) != 0 {
+			flags = append(flags, flag)
+		}
+	}
+	return flags
+}
+
+var (
+	fuzzCorpusOnce sync.Once
+	fuzzCorpus     []corpus.Message
+)
+
+// fuzzMessages returns messages from corpus used as base for fuzzed
+// messages.
+func fuzzMessages() []corpus.Message {
+	fuzzCorpusOnce.Do(func() {
+		var err error
+		fuzzCorpus, err = corpus.Messages()
+		if err != nil {
+			panic(err)
+		}
+	})
+	return fuzzCorpus
+}
+
+// body decodes message body. It is either message from corpus with some
+// bytes replaced or raw bytes from stream.
+func (r *fuzzReader) body(op *fuzzOp) {
+	sel := r.byte()
+	if sel&0x80 != 0 {
+		n := int(r.byte())
+		for i := 0; i < n && !r.done(); i++ {
+			op.body = append(op.body, r.byte())
+		}
+		op.bodyDesc = fmt.Sprintf("raw %q", op.body)
+		op.fuzzed = true
+		return
+	}
+
+	msgs := fuzzMessages()
+	msg := msgs[int(sel)%len(msgs)]
+	op.body = append([]byte(nil), msg.Raw...)
+	op.bodyDesc = "corpus " + msg.Name
+	mutations := int(r.byte() % 4)
+	for i := 0; i < mutations; i++ {
+		pos := (int(r.byte())<<8 | int(r.byte())) % len(op.body)
+		val := r.byte()
+		op.body[pos] = val
+		op.bodyDesc += fmt.Sprintf(" [%d]=%q", pos, val)
+		op.fuzzed = true
+	}
+}
+
+// decodeFuzzOps decodes operations from byte stream, any stream is valid.
+func decodeFuzzOps(data []byte) []fuzzOp {
+	r := &fuzzReader{data: data}
+	var ops []fuzzOp
+	for !r.done() && len(ops) < fuzzMaxOps {
+		op := fuzzOp{kind: fuzzOpKind(r.byte() % byte(fuzzOpKinds))}
+		switch op.kind {
+		case fuzzCreateMailbox, fuzzDeleteMailbox:
+			op.mbox = r.mailbox(false)
+		case fuzzRenameMailbox:
+			op.mbox = r.mailbox(false)
+			op.target = r.mailbox(false)
+		case fuzzCreateMessage:
+			op.mbox = r.mailbox(true)
+			op.flags = r.flags()
+			r.body(&op)
+		case fuzzUpdateFlags:
+			op.mbox = r.mailbox(true)
+			op.uid = r.byte()&1 != 0
+			op.first, op.last = r.byte(), r.byte()
+			op.flagsOp = []imap.FlagsOp{imap.SetFlags, imap.AddFlags, imap.RemoveFlags}[r.byte()%3]
+			op.flags = r.flags()
+		case fuzzCopy:
+			op.mbox = r.mailbox(true)
+			op.target = r.mailbox(true)
+			op.first, op.last = r.byte(), r.byte()
+		case fuzzExpunge:
+			op.mbox = r.mailbox(true)
+		case fuzzList:
+			op.mbox = r.mailbox(true)
+			op.first, op.last = r.byte(), r.byte()
+			section := fuzzSections[int(r.byte())%len(fuzzSections)]
+			item := "BODY.PEEK[" + section + "]"
+			if partial := r.byte(); partial%4 == 0 {
+				item += fmt.Sprintf("<%d.%d>", r.byte(), 1+int(r.byte()))
+			}
+			op.item = imap.FetchItem(item)
+		}
+		ops = append(ops, op)
+	}
+	return ops
+}
+
+// fuzzModel is the reference model of user's mailboxes. Mailboxes are
+// selected again for each operation, so \Recent is not checked.
+type fuzzModel struct {
+	mailboxes map[string]*modelMailbox
+}
+
+func newFuzzModel() *fuzzModel {
+	return &fuzzModel{mailboxes: map[string]*modelMailbox{"INBOX": {}}}
+}
+
+// fuzzRun executes operations against backend and model.
+type fuzzRun struct {
+	ops   []fuzzOp
+	u     backend.User
+	model *fuzzModel
+	// trace contains descriptions of executed operations.
+	trace []string
+}
+
+// runFuzzOps executes operations against new backend and reference model.
+// Returned trace contains descriptions of executed operations. Panics are
+// returned as errors.
+func runFuzzOps(newBack NewBackFunc, closeBack CloseBackFunc, ops []fuzzOp) ([]string, error) {
+	run := &fuzzRun{ops: ops, model: newFuzzModel()}
+	err := runOps(newBack, closeBack, fuzzUser, len(ops), run)
+	return run.trace, err
+}
+
+func (r *fuzzRun) setup(u backend.User) error {
+	r.u = u
+	return nil
+}
+
+// expectError checks that err is not nil if operation should fail.
+func expectError(err error, fail bool, desc string) error {
+	if fail && err == nil {
+		return fmt.Errorf("%s: expected error, got success", desc)
+	}
+	if !fail && err != nil {
+		return fmt.Errorf("%s: unexpected error: %v", desc, err)
+	}
+	return nil
+}
+
+func (r *fuzzRun) exec(step int) error {
+	op := &r.ops[step]
+	m := r.model
+	_, exists := m.mailboxes[op.mbox]
+	_, targetExists := m.mailboxes[op.target]
+
+	switch op.kind {
+	case fuzzCreateMailbox:
+		desc := "CREATE " + op.mbox
+		r.trace = append(r.trace, desc)
+		if err := expectError(r.u.CreateMailbox(op.mbox), exists, desc); err != nil {
+			return err
+		}
+		if !exists {
+			m.mailboxes[op.mbox] = &modelMailbox{}
+		}
+		return nil
+	case fuzzRenameMailbox:
+		desc := "RENAME " + op.mbox + " " + op.target
+		r.trace = append(r.trace, desc)
+		fail := !exists || targetExists
+		if err := expectError(r.u.RenameMailbox(op.mbox, op.target), fail, desc); err != nil {
+			return err
+		}
+		if !fail {
+			// UIDs may be changed by RENAME.
+			model := m.mailboxes[op.mbox]
+			model.resetUIDs()
+			m.mailboxes[op.target] = model
+			delete(m.mailboxes, op.mbox)
+		}
+		return nil
+	case fuzzDeleteMailbox:
+		desc := "DELETE " + op.mbox
+		r.trace = append(r.trace, desc)
+		if err := expectError(r.u.DeleteMailbox(op.mbox), !exists, desc); err != nil {
+			return err
+		}
+		delete(m.mailboxes, op.mbox)
+		return nil
+	}
+
+	mbox, err := r.u.GetMailbox(op.mbox)
+	if err := expectError(err, !exists, "GetMailbox "+op.mbox); err != nil {
+		r.trace = append(r.trace, "SELECT "+op.mbox)
+		return err
+	}
+	if !exists {
+		r.trace = append(r.trace, "SELECT "+op.mbox+" (no mailbox)")
+		return nil
+	}
+
+	if op.kind == fuzzCreateMessage {
+		desc := fmt.Sprintf("APPEND %s %v %s", op.mbox, op.flags, op.bodyDesc)
+		r.trace = append(r.trace, desc)
+		err := mbox.CreateMessage(copyFlags(op.flags), time.Now(), bytes.NewReader(op.body))
+		if err != nil && op.fuzzed {
+			// Backend may reject malformed message.
+			r.trace[len(r.trace)-1] += " (rejected)"
+			return nil
+		}
+		if err := expectError(err, false, desc); err != nil {
+			return err
+		}
+		m.mailboxes[op.mbox].append(op.flags, op.body)
+		return nil
+	}
+	if op.kind == fuzzExpunge {
+		r.trace = append(r.trace, "EXPUNGE "+op.mbox)
+		if err := mbox.Expunge(); err != nil {
+			return fmt.Errorf("EXPUNGE %s: unexpected error: %v", op.mbox, err)
+		}
+		m.mailboxes[op.mbox].expunge()
+		return nil
+	}
+
+	model := m.mailboxes[op.mbox]
+	first, last, ok := model.seqRange(int(op.first), int(op.last))
+	if !ok {
+		r.trace = append(r.trace, fmt.Sprintf("(operation %d on empty %s)", op.kind, op.mbox))
+		return nil
+	}
+	msgs := model.msgs
+	seq := new(imap.SeqSet)
+	seq.AddRange(first, last)
+
+	switch op.kind {
+	case fuzzUpdateFlags:
+		seqStr := seq.String()
+		if op.uid {
+			// UIDs of all messages are known after check.
+			seq = new(imap.SeqSet)
+			seq.AddRange(msgs[first-1].uid, msgs[last-1].uid)
+			seqStr = "UID " + seq.String()
+		}
+		desc := fmt.Sprintf("STORE %s %s %s %v", op.mbox, seqStr, op.flagsOp, op.flags)
+		r.trace = append(r.trace, desc)
+		if err := expectError(mbox.UpdateMessagesFlags(op.uid, seq, op.flagsOp, copyFlags(op.flags)), false, desc); err != nil {
+			return err
+		}
+		model.updateFlags(first, last, op.flagsOp, op.flags)
+	case fuzzCopy:
+		desc := fmt.Sprintf("COPY %s %s to %s", op.mbox, seq, op.target)
+		r.trace = append(r.trace, desc)
+		if err := expectError(mbox.CopyMessages(false, seq, op.target), !targetExists, desc); err != nil {
+			return err
+		}
+		if targetExists {
+			model.copyTo(m.mailboxes[op.target], first, last)
+		}
+	case fuzzList:
+		desc := fmt.Sprintf("FETCH %s %s %s", op.mbox, seq, op.item)
+		r.trace = append(r.trace, desc)
+		return r.checkList(mbox, seq, msgs[first-1:last], op.item, desc)
+	}
+	return nil
+}
+
+// checkList compares body section returned by backend with section computed
+// by backendutil.
+func (r *fuzzRun) checkList(mbox backend.Mailbox, seq *imap.SeqSet, msgs []modelMsg, item imap.FetchItem, desc string) error {
+	section, err := imap.ParseBodySectionName(item)
+	if err != nil {
+		return err
+	}
+	expected := make([][]byte, len(msgs))
+	defined := true
+	for i, msg := range msgs {
+		expected[i], err = corpus.ReferenceSection(msg.body, section)
+		if err != nil {
+			defined = false
+		}
+	}
+
+	ch := make(chan *imap.Message, 10)
+	done := make(chan error, 1)
+	go func() {
+		done <- mbox.ListMessages(false, seq, []imap.FetchItem{item}, ch)
+	}()
+	var actual []*imap.Message
+	for msg := range ch {
+		actual = append(actual, msg)
+	}
+	if err := <-done; err != nil {
+		if defined {
+			return fmt.Errorf("%s: unexpected error: %v", desc, err)
+		}
+		return nil
+	}
+
+	if len(actual) != len(msgs) {
+		return fmt.Errorf("%s: %d messages returned, expected %d", desc, len(actual), len(msgs))
+	}
+	for _, res := range actual {
+		indx := int(res.SeqNum) - int(seq.Set[0].Start)
+		if indx < 0 || indx >= len(msgs) {
+			return fmt.Errorf("%s: unexpected message %d returned", desc, res.SeqNum)
+		}
+		if expected[indx] == nil {
+			continue
+		}
+		var lit imap.Literal
+		for _, l := range res.Body {
+			lit = l
+		}
+		if lit == nil {
+			return fmt.Errorf("%s: section is not returned for message %d", desc, res.SeqNum)
+		}
+		body, err := ioutil.ReadAll(lit)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(body, expected[indx]) {
+			return fmt.Errorf("%s: wrong section of message %d:\n%q\nexpected:\n%q", desc, res.SeqNum, body, expected[indx])
+		}
+	}
+	return nil
+}
+
+// check compares the list of mailboxes and UIDs and flags of messages with
+// model. Unknown UIDs in model are filled from backend.
+func (r *fuzzRun) check() error {
+	mboxes, err := r.u.ListMailboxes(false)
+	if err != nil {
+		return fmt.Errorf("ListMailboxes: %v", err)
+	}
+	names := make([]string, 0, len(mboxes))
+	for _, mbox := range mboxes {
+		names = append(names, mbox.Name())
+	}
+	sort.Strings(names)
+	expected := make([]string, 0, len(r.model.mailboxes))
+	for name := range r.model.mailboxes {
+		expected = append(expected, name)
+	}
+	sort.Strings(expected)
+	if strings.Join(names, " ") != strings.Join(expected, " ") {
+		return fmt.Errorf("mailboxes are %v, expected %v", names, expected)
+	}
+
+	for _, name := range expected {
+		mbox, err := r.u.GetMailbox(name)
+		if err != nil {
+			return fmt.Errorf("GetMailbox %s: %v", name, err)
+		}
+		if err := r.model.mailboxes[name].check(mbox); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// minimizeFuzzOps removes operations that are not needed to reproduce
+// failure. Returned trace and error are from the last failed run.
+func minimizeFuzzOps(newBack NewBackFunc, closeBack CloseBackFunc, ops []fuzzOp, trace []string, err error) ([]fuzzOp, []string, error) {
+	subset := func(steps []int) []fuzzOp {
+		res := make([]fuzzOp, 0, len(steps))
+		for _, step := range steps {
+			res = append(res, ops[step])
+		}
+		return res
+	}
+	steps, err := shrinkSteps(len(ops), err, func(steps []int) error {
+		candTrace, candErr := runFuzzOps(newBack, closeBack, subset(steps))
+		if candErr != nil {
+			trace = candTrace
+		}
+		return candErr
+	})
+	return subset(steps), trace, err
+}
+
+// formatFuzzTrace returns numbered list of operations.
+func formatFuzzTrace(trace []string) string {
+	var b strings.Builder
+	for i, desc := range trace {
+		fmt.Fprintf(&b, "%d: %s\n", i+1, desc)
+	}
+	return b.String()
+}
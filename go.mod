@@ -1,9 +1,9 @@
 module github.com/foxcpp/go-imap-backend-tests
 
-go 1.12
+go 1.16
 
 require (
-	github.com/emersion/go-imap v1.0.0-beta.4.0.20190504114255-4d5af3d05147
+	github.com/emersion/go-imap v1.0.0-rc.1
 	github.com/emersion/go-imap-appendlimit v0.0.0-20190308131241-25671c986a6a
 	github.com/emersion/go-imap-move v0.0.0-20180601155324-5eb20cb834bf
 	github.com/emersion/go-message v0.10.3
@@ -0,0 +1,123 @@
+package backendtests
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/emersion/go-imap"
+	"github.com/foxcpp/go-imap-backend-tests/corpus"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+// Expected ENVELOPE and BODYSTRUCTURE of messages from corpus package are
+// golden files generated using go-imap backendutil package. Differences that
+// are not significant for IMAP clients are removed before comparison, as well
+// as BODYSTRUCTURE fields that are not set in expected results (see
+// normalizeBodyStructure).
+
+// goldenMessage returns message with specified name from corpus package.
+func goldenMessage(t *testing.T, name string) corpus.Message {
+	t.Helper()
+
+	msg, err := corpus.Load(name)
+	assert.NilError(t, err, "Failed to load %s from corpus", name)
+	return msg
+}
+
+// normalizeEnvelope returns copy of env with empty address lists set to
+// non-nil values.
+func normalizeEnvelope(env *imap.Envelope) *imap.Envelope {
+	if env == nil {
+		return nil
+	}
+	res := *env
+	stripEnvelope(&res)
+	return &res
+}
+
+// normalizeBodyStructure returns deep copy of bs with empty values set to
+// non-nil values and case-insensitive values (MIME type, parameter names,
+// encoding and disposition) lowercased.
+//
+// Fields backendutil doesn't compute (encoding, size, number of lines, MD5,
+// language, location and ENVELOPE and BODYSTRUCTURE of message/rfc822 parts)
+// are cleared unless they are set in the corresponding part of expected.
+// Golden files store them only for messages they were verified by hand for.
+func normalizeBodyStructure(bs, expected *imap.BodyStructure) *imap.BodyStructure {
+	if bs == nil {
+		return nil
+	}
+	if expected == nil {
+		expected = &imap.BodyStructure{}
+	}
+	res := *bs
+	res.MIMEType = strings.ToLower(bs.MIMEType)
+	res.MIMESubType = strings.ToLower(bs.MIMESubType)
+	res.Encoding = strings.ToLower(bs.Encoding)
+	res.Disposition = strings.ToLower(bs.Disposition)
+	res.Params = lowerKeys(bs.Params)
+	res.DispositionParams = lowerKeys(bs.DispositionParams)
+	res.Envelope = normalizeEnvelope(bs.Envelope)
+	res.BodyStructure = normalizeBodyStructure(bs.BodyStructure, expected.BodyStructure)
+	if expected.Encoding == "" {
+		res.Encoding = ""
+	}
+	if expected.Size == 0 {
+		res.Size = 0
+	}
+	if expected.Lines == 0 {
+		res.Lines = 0
+	}
+	if expected.MD5 == "" {
+		res.MD5 = ""
+	}
+	if expected.Language == nil {
+		res.Language = nil
+	}
+	if expected.Location == nil {
+		res.Location = nil
+	}
+	if expected.Envelope == nil {
+		res.Envelope = nil
+	}
+	if expected.BodyStructure == nil {
+		res.BodyStructure = nil
+	}
+	res.Parts = make([]*imap.BodyStructure, 0, len(bs.Parts))
+	for i, part := range bs.Parts {
+		var expectedPart *imap.BodyStructure
+		if i < len(expected.Parts) {
+			expectedPart = expected.Parts[i]
+		}
+		res.Parts = append(res.Parts, normalizeBodyStructure(part, expectedPart))
+	}
+	stripBodyStructure(&res)
+	return &res
+}
+
+func lowerKeys(m map[string]string) map[string]string {
+	res := make(map[string]string, len(m))
+	for k, v := range m {
+		res[strings.ToLower(k)] = v
+	}
+	return res
+}
+
+// checkEnvelope compares ENVELOPE returned by backend with expected one,
+// difference is reported if they are not equal after normalization.
+func checkEnvelope(t *testing.T, actual, expected *imap.Envelope) {
+	t.Helper()
+
+	assert.Assert(t, actual != nil, "ENVELOPE is not returned")
+	assert.Assert(t, is.DeepEqual(normalizeEnvelope(actual), normalizeEnvelope(expected)), "ENVELOPE differs (-returned +expected)")
+}
+
+// checkBodyStructure compares BODYSTRUCTURE returned by backend with expected
+// one, difference is reported if they are not equal after normalization.
+func checkBodyStructure(t *testing.T, actual, expected *imap.BodyStructure) {
+	t.Helper()
+
+	assert.Assert(t, actual != nil, "BODYSTRUCTURE is not returned")
+	assert.Assert(t, is.DeepEqual(normalizeBodyStructure(actual, expected), normalizeBodyStructure(expected, expected)), "BODYSTRUCTURE differs (-returned +expected)")
+}
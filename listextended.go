@@ -0,0 +1,61 @@
+package backendtests
+
+import "github.com/emersion/go-imap"
+
+// Mailbox attributes defined in RFC 5258.
+const (
+	NonExistentAttr = `\NonExistent`
+	SubscribedAttr  = `\Subscribed`
+	RemoteAttr      = `\Remote`
+)
+
+// ListOptions contains LIST-EXTENDED selection and return options.
+type ListOptions struct {
+	// SUBSCRIBED selection option, only subscribed mailboxes (including
+	// non-existent ones) should be returned.
+	Subscribed bool
+	// REMOTE selection option, remote mailboxes should be returned too.
+	Remote bool
+	// RECURSIVEMATCH selection option, parents of mailboxes matched by other
+	// selection options should be returned with CHILDINFO. Never used alone.
+	RecursiveMatch bool
+
+	// SUBSCRIBED return option, \Subscribed attribute should be set on
+	// subscribed mailboxes.
+	ReturnSubscribed bool
+}
+
+// ListInfo is a single entry of LIST-EXTENDED response.
+type ListInfo struct {
+	imap.MailboxInfo
+
+	// ChildInfo contains values of CHILDINFO extended data item (e.g.
+	// "SUBSCRIBED").
+	ChildInfo []string
+
+	// Status is set only by ListStatusUser.ListMailboxesStatus for
+	// selectable mailboxes.
+	Status *imap.MailboxStatus
+}
+
+// ListExtendedUser is extension for backend.User interface which allows to
+// list mailboxes using RFC 5258 LIST-EXTENDED selection options.
+type ListExtendedUser interface {
+	// ListMailboxesExtended returns mailboxes matching any of passed patterns
+	// (relative to reference name) and selection options.
+	//
+	// Error should be returned if RecursiveMatch is set without any other
+	// selection option.
+	ListMailboxesExtended(ref string, patterns []string, opts *ListOptions) ([]ListInfo, error)
+}
+
+// ListStatusUser is extension for backend.User interface which allows to
+// request mailbox status in LIST command, as defined in RFC 5819.
+type ListStatusUser interface {
+	ListExtendedUser
+
+	// ListMailboxesStatus is same as ListMailboxesExtended but also sets
+	// Status field with requested items for each listed mailbox. Status
+	// should be nil for mailboxes with \Noselect or \NonExistent attribute.
+	ListMailboxesStatus(ref string, patterns []string, opts *ListOptions, items []imap.StatusItem) ([]ListInfo, error)
+}
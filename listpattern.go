@@ -0,0 +1,13 @@
+package backendtests
+
+import "github.com/emersion/go-imap/backend"
+
+// PatternLister is extension for backend.User interface which allows backend
+// to match LIST and LSUB patterns itself (e.g. in SQL query) instead of
+// returning all mailboxes.
+type PatternLister interface {
+	// ListMailboxesPattern returns mailboxes matching pattern interpreted
+	// relatively to reference name, as defined in RFC 3501, Section 6.3.8.
+	// If subscribed is set to true, only subscribed mailboxes are returned.
+	ListMailboxesPattern(subscribed bool, ref, pattern string) ([]backend.Mailbox, error)
+}
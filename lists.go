@@ -8,7 +8,27 @@ import (
 var Blacklist []string
 var Whitelist []string
 
-func skipIfExcluded(t *testing.T) {
+// runnerExcluded contains full names of tests that are skipped because they
+// are not applicable to the running test mode, see excludeTests. Subtests of
+// excluded tests are skipped too, tests that only share name prefix are not.
+var runnerExcluded []string
+
+// excludeTests adds tests (names relative to RunTests) run under parent to
+// runnerExcluded. Returned function should be called to remove them after
+// the run.
+func excludeTests(parent string, tests []string) func() {
+	prev := runnerExcluded
+	runnerExcluded = make([]string, 0, len(prev)+len(tests))
+	runnerExcluded = append(runnerExcluded, prev...)
+	for _, test := range tests {
+		runnerExcluded = append(runnerExcluded, parent+"/"+test)
+	}
+	return func() {
+		runnerExcluded = prev
+	}
+}
+
+func skipIfExcluded(t testing.TB) {
 	if Whitelist != nil {
 		whitelisted := false
 		for _, included := range Whitelist {
@@ -28,4 +48,11 @@ func skipIfExcluded(t *testing.T) {
 			t.SkipNow()
 		}
 	}
+
+	for _, excluded := range runnerExcluded {
+		if t.Name() == excluded || strings.HasPrefix(t.Name(), excluded+"/") {
+			t.Skip("not applicable to this test mode")
+			t.SkipNow()
+		}
+	}
 }
@@ -202,6 +202,107 @@ func Mailbox_SetSubscribed(t *testing.T, newBack NewBackFunc, closeBack CloseBac
 		}
 		assert.Assert(t, !present, "Mailbox is present in list when unsubscribed")
 	})
+	t.Run("Rename", func(t *testing.T) {
+		skipIfExcluded(t)
+
+		b := newBack()
+		defer closeBack(b)
+		u := getUser(t, b)
+		defer assert.NilError(t, u.Logout())
+
+		assert.NilError(t, getNamedMbox(t, u, "TEST").SetSubscribed(true))
+		assert.NilError(t, getNamedMbox(t, u, "TEST.CHILD").SetSubscribed(true))
+
+		assert.NilError(t, u.RenameMailbox("TEST", "TEST2"))
+
+		subscribed := mailboxNames(t, u, true)
+		assert.Check(t, is.Contains(subscribed, "TEST2"), "Subscription is lost on rename")
+		assert.Check(t, is.Contains(subscribed, "TEST2.CHILD"), "Subscription is lost on rename of parent")
+
+		// Old names may stay in subscriptions list, but they are not
+		// mailboxes anymore.
+		checkNonExistentSubscription(t, u, "TEST")
+		checkNonExistentSubscription(t, u, "TEST.CHILD")
+	})
+	t.Run("Delete", func(t *testing.T) {
+		skipIfExcluded(t)
+
+		b := newBack()
+		defer closeBack(b)
+		u := getUser(t, b)
+		defer assert.NilError(t, u.Logout())
+
+		assert.NilError(t, getNamedMbox(t, u, "TEST").SetSubscribed(true))
+		assert.NilError(t, u.DeleteMailbox("TEST"))
+
+		_, err := u.GetMailbox("TEST")
+		assert.Error(t, err, backend.ErrNoSuchMailbox.Error(), "Deleted mailbox is still accessible")
+		assert.Check(t, !is.Contains(mailboxNames(t, u, false), "TEST")().Success(), "Deleted mailbox is still listed")
+
+		// RFC 3501, Section 6.3.6: Server MUST NOT unilaterally remove
+		// mailbox name from subscriptions list even if mailbox doesn't exist anymore.
+		// Backend may still do so, since it's a frontend's job.
+		keptSubscription := checkNonExistentSubscription(t, u, "TEST")
+
+		assert.NilError(t, u.CreateMailbox("TEST"))
+		mbox, err := u.GetMailbox("TEST")
+		assert.NilError(t, err)
+		info, err := mbox.Info()
+		assert.NilError(t, err)
+		assert.Check(t, !hasAttr(info.Attributes, imap.NoSelectAttr), "Re-created mailbox has \\Noselect attribute")
+
+		if keptSubscription {
+			assert.Check(t, is.Contains(mailboxNames(t, u, true), "TEST"), "Subscription is lost after mailbox re-creation")
+		}
+	})
+	t.Run("Per-user", func(t *testing.T) {
+		skipIfExcluded(t)
+
+		b := newBack()
+		defer closeBack(b)
+		u1 := getNamedUser(t, b, "username1")
+		defer assert.NilError(t, u1.Logout())
+		u2 := getNamedUser(t, b, "username2")
+		defer assert.NilError(t, u2.Logout())
+
+		mbox1 := getNamedMbox(t, u1, "TEST")
+		mbox2 := getNamedMbox(t, u2, "TEST")
+
+		assert.NilError(t, mbox1.SetSubscribed(true))
+		assert.Check(t, is.Contains(mailboxNames(t, u1, true), "TEST"), "Mailbox is not present in list when subscribed")
+		assert.Check(t, !is.Contains(mailboxNames(t, u2, true), "TEST")().Success(), "Subscription is visible to another user")
+
+		assert.NilError(t, mbox2.SetSubscribed(true))
+		assert.NilError(t, mbox2.SetSubscribed(false))
+		assert.Check(t, is.Contains(mailboxNames(t, u1, true), "TEST"), "Unsubscribe by another user removed subscription")
+	})
+}
+
+// checkNonExistentSubscription checks that name is not listed as an existing
+// mailbox and if it is still present in subscriptions list, it is reported as
+// non-selectable.
+//
+// It returns true if name is present in subscriptions list.
+func checkNonExistentSubscription(t *testing.T, u backend.User, name string) bool {
+	t.Helper()
+
+	assert.Check(t, !is.Contains(mailboxNames(t, u, false), name)().Success(), "Non-existent mailbox %s is listed", name)
+
+	mboxes, err := u.ListMailboxes(true)
+	assert.NilError(t, err)
+	for _, mbox := range mboxes {
+		if mbox.Name() != name {
+			continue
+		}
+
+		info, err := mbox.Info()
+		assert.NilError(t, err)
+		if !hasAttr(info.Attributes, imap.NoSelectAttr) && !hasAttr(info.Attributes, NonExistentAttr) {
+			t.Errorf("Subscribed non-existent mailbox %s is not reported as \\Noselect or \\NonExistent: %v", name, info.Attributes)
+		}
+		return true
+	}
+	return false
 }
 
 func Mailbox_CreateMessage(t *testing.T, newBack NewBackFunc, closeBack CloseBackFunc) {
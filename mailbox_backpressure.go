@@ -0,0 +1,182 @@
+package backendtests
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/backend"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+// backpressureOps is the amount of operations executed while nobody reads
+// updates.
+const backpressureOps = 200
+
+// runWithoutReading executes op count times while updates are not read. Test
+// fails if any operation doesn't complete in UpdateTimeout (scaled by
+// TimeoutScale and limited by test deadline). In this case updates are read to unblock backend and
+// returned.
+func runWithoutReading(t *testing.T, upds <-chan backend.Update, count int, op func(i int) error) []backend.Update {
+	t.Helper()
+
+	progress := make(chan error)
+	go func() {
+		for i := 0; i < count; i++ {
+			err := op(i)
+			progress <- err
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	var (
+		read    []backend.Update
+		blocked bool
+	)
+	ctx, cancel := waitContext(t, UpdateTimeout)
+	defer func() { cancel() }()
+	for done := 0; done < count; {
+		// Updates are read only after backend is detected to block.
+		var readCh <-chan backend.Update
+		if blocked {
+			readCh = upds
+		}
+
+		select {
+		case err := <-progress:
+			assert.NilError(t, err)
+			done++
+			cancel()
+			ctx, cancel = waitContext(t, UpdateTimeout)
+		case upd := <-readCh:
+			read = append(read, upd)
+		case <-ctx.Done():
+			if blocked {
+				t.Fatalf("Operation %d is not completed even when updates are read (%s, deadlock?), read updates: %s", done+1, waitError(ctx), describeUpdates(read))
+			}
+			t.Errorf("Operation %d is blocked because updates are not read (%s)", done+1, waitError(ctx))
+			blocked = true
+			cancel()
+			ctx, cancel = waitContext(t, UpdateTimeout)
+		}
+	}
+	return read
+}
+
+// checkUnreadUpdates checks that no updates are lost while they were not read
+// unless backend implements UpdateDropBackend, in this case at least
+// UpdatesBufferSize updates should be kept.
+//
+// It returns true if all expected updates are received.
+func checkUnreadUpdates(t *testing.T, b Backend, received, expected int) bool {
+	t.Helper()
+
+	dropper, ok := b.(UpdateDropBackend)
+	if !ok {
+		assert.Check(t, is.Equal(received, expected), "Updates are lost while not read")
+		return received == expected
+	}
+
+	kept := dropper.UpdatesBufferSize()
+	if kept > expected {
+		kept = expected
+	}
+	assert.Check(t, received >= kept, "Backend dropped updates before buffer is full: received %d of %d, buffer size is %d", received, expected, dropper.UpdatesBufferSize())
+	assert.Check(t, received <= expected, "Backend sent more updates than expected: %d > %d", received, expected)
+	return received == expected
+}
+
+// Mailbox_UpdatesBackpressure checks that operations neither block nor lose
+// updates if nobody reads backend updates for a while.
+func Mailbox_UpdatesBackpressure(t *testing.T, newBack NewBackFunc, closeBack CloseBackFunc) {
+	b := newBack()
+	defer closeBack(b)
+
+	updater, ok := b.(backend.BackendUpdater)
+	if !ok {
+		t.Skip("Backend doesn't supports unilateral updates (need backend.BackendUpdater interface)")
+		t.SkipNow()
+	}
+	upds := updater.Updates()
+
+	u := getNamedUser(t, b, "username1")
+	defer assert.NilError(t, u.Logout())
+	mbox := getNamedMbox(t, u, "TEST")
+	collectUpdates(t, upds)
+
+	t.Run("Append", func(t *testing.T) {
+		skipIfExcluded(t)
+
+		sess := newUpdateSession(t, u.Username(), mbox)
+		read := runWithoutReading(t, upds, backpressureOps, func(int) error {
+			return mbox.CreateMessage([]string{}, time.Now(), strings.NewReader(testMailString))
+		})
+		read = append(read, collectUpdates(t, upds)...)
+
+		received := 0
+		prevCount := uint32(len(sess.msgs))
+		inOrder := true
+		for _, upd := range read {
+			mboxUpd, ok := upd.(*backend.MailboxUpdate)
+			if !ok || !sess.matches(upd) {
+				continue
+			}
+			received++
+			if _, ok := mboxUpd.Items[imap.StatusMessages]; ok {
+				if mboxUpd.Messages <= prevCount {
+					inOrder = false
+				}
+				prevCount = mboxUpd.Messages
+			}
+		}
+
+		if checkUnreadUpdates(t, b, received, backpressureOps) {
+			assert.Check(t, inOrder, "MailboxUpdates are not in order after reading is resumed")
+			for _, upd := range read {
+				if sess.matches(upd) {
+					sess.apply(t, upd)
+				}
+			}
+			sess.check(t, mbox)
+		}
+	})
+	t.Run("Flags", func(t *testing.T) {
+		skipIfExcluded(t)
+
+		createMsgs(t, mbox, 3)
+		collectUpdates(t, upds)
+
+		sess := newUpdateSession(t, u.Username(), mbox)
+		read := runWithoutReading(t, upds, backpressureOps, func(i int) error {
+			// Each operation changes flags of one message.
+			seq := new(imap.SeqSet)
+			seq.AddNum(uint32(i%3 + 1))
+			flags := []string{}
+			if (i/3)%2 == 0 {
+				flags = append(flags, imap.FlaggedFlag)
+			}
+			return mbox.UpdateMessagesFlags(false, seq, imap.SetFlags, flags)
+		})
+		read = append(read, collectUpdates(t, upds)...)
+
+		received := 0
+		for _, upd := range read {
+			if _, ok := upd.(*backend.MessageUpdate); ok && sess.matches(upd) {
+				received++
+			}
+		}
+
+		if checkUnreadUpdates(t, b, received, backpressureOps) {
+			for _, upd := range read {
+				if sess.matches(upd) {
+					sess.apply(t, upd)
+				}
+			}
+			sess.check(t, mbox)
+		}
+	})
+}
@@ -0,0 +1,260 @@
+package backendtests
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+// contextMsgs is the amount of messages in mailbox used to test
+// cancellation, operations on it should take noticeable time.
+const contextMsgs = 300
+
+// cancelingLiteral returns first cancelAfter bytes of message and then
+// cancels context, remaining bytes are returned as usual.
+type cancelingLiteral struct {
+	*strings.Reader
+	cancel      context.CancelFunc
+	cancelAfter int
+	read        int
+}
+
+func (l *cancelingLiteral) Read(b []byte) (int, error) {
+	if l.read >= l.cancelAfter {
+		l.cancel()
+	} else if len(b) > l.cancelAfter-l.read {
+		b = b[:l.cancelAfter-l.read]
+	}
+	n, err := l.Reader.Read(b)
+	l.read += n
+	return n, err
+}
+
+// cancelingContext cancels itself when Done or Err is called for the
+// cancelAfter-th time, so operation that checks context for each message is
+// cancelled while it is executed.
+type cancelingContext struct {
+	context.Context
+	cancel      context.CancelFunc
+	cancelAfter int32
+	checks      int32
+}
+
+func (c *cancelingContext) check() {
+	if atomic.AddInt32(&c.checks, 1) >= c.cancelAfter {
+		c.cancel()
+	}
+}
+
+func (c *cancelingContext) Done() <-chan struct{} {
+	c.check()
+	return c.Context.Done()
+}
+
+func (c *cancelingContext) Err() error {
+	c.check()
+	return c.Context.Err()
+}
+
+// checkCancelled checks that err is context.Canceled returned by operation.
+func checkCancelled(t *testing.T, err error) {
+	t.Helper()
+	assert.Check(t, errors.Is(err, context.Canceled), "Expected context.Canceled error, got %v", err)
+}
+
+// waitReturn waits for operation to return after context cancellation.
+func waitReturn(t *testing.T, done <-chan error, operation string) error {
+	t.Helper()
+
+	ctx, cancel := waitContext(t, CancelTimeout)
+	defer cancel()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		t.Fatalf("%s doesn't return after context cancellation (%s)", operation, waitError(ctx))
+	}
+	return nil
+}
+
+func Mailbox_Context(t *testing.T, newBack NewBackFunc, closeBack CloseBackFunc) {
+	b := newBack()
+	defer closeBack(b)
+	u := getUser(t, b)
+	defer assert.NilError(t, u.Logout())
+	mbox := getMbox(t, u)
+
+	_, list := mbox.(ListContextMailbox)
+	_, search := mbox.(SearchContextMailbox)
+	_, create := mbox.(CreateContextMailbox)
+	if !list && !search && !create {
+		t.Skip("Backend doesn't supports cancellation (need ListContextMailbox, SearchContextMailbox or CreateContextMailbox interface)")
+		t.SkipNow()
+	}
+	createMsgs(t, mbox, contextMsgs)
+
+	t.Run("ListMessages", func(t *testing.T) {
+		skipIfExcluded(t)
+
+		lmbox, ok := mbox.(ListContextMailbox)
+		if !ok {
+			t.Skip("Backend doesn't supports cancellation of ListMessages (need ListContextMailbox interface)")
+			t.SkipNow()
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		seq, _ := imap.ParseSeqSet("1:*")
+		ch := make(chan *imap.Message)
+		done := make(chan error, 1)
+		go func() {
+			done <- lmbox.ListMessagesContext(ctx, false, seq, []imap.FetchItem{imap.FetchUid, "BODY.PEEK[]"}, ch)
+		}()
+
+		received := 0
+		for ; received < 5; received++ {
+			_, ok := <-ch
+			assert.Assert(t, ok, "Channel is closed before all messages are sent")
+		}
+		cancel()
+		// Messages are not read for some time after cancellation, backend
+		// should not wait until they are read.
+		time.Sleep(scaled(CancelTimeout) / 4)
+
+		waitCtx, waitCancel := waitContext(t, CancelTimeout)
+		defer waitCancel()
+		for closed := false; !closed; {
+			select {
+			case _, ok := <-ch:
+				if !ok {
+					closed = true
+				} else {
+					received++
+				}
+			case <-waitCtx.Done():
+				t.Fatalf("Channel is not closed after context cancellation (%s)", waitError(waitCtx))
+			}
+		}
+		checkCancelled(t, waitReturn(t, done, "ListMessagesContext"))
+		assert.Check(t, received < contextMsgs, "All messages are sent after context cancellation")
+	})
+	t.Run("ListMessages cancelled before call", func(t *testing.T) {
+		skipIfExcluded(t)
+
+		lmbox, ok := mbox.(ListContextMailbox)
+		if !ok {
+			t.Skip("Backend doesn't supports cancellation of ListMessages (need ListContextMailbox interface)")
+			t.SkipNow()
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		seq, _ := imap.ParseSeqSet("1:*")
+		ch := make(chan *imap.Message, contextMsgs)
+		err := lmbox.ListMessagesContext(ctx, false, seq, []imap.FetchItem{imap.FetchUid}, ch)
+		checkCancelled(t, err)
+
+		waitCtx, waitCancel := waitContext(t, CancelTimeout)
+		defer waitCancel()
+		received := 0
+		for closed := false; !closed; {
+			select {
+			case _, ok := <-ch:
+				if !ok {
+					closed = true
+				} else {
+					received++
+				}
+			case <-waitCtx.Done():
+				t.Fatalf("Channel is not closed after ListMessagesContext returned (%s)", waitError(waitCtx))
+			}
+		}
+		assert.Check(t, received < contextMsgs, "All messages are sent for cancelled context")
+	})
+	t.Run("SearchMessages", func(t *testing.T) {
+		skipIfExcluded(t)
+
+		smbox, ok := mbox.(SearchContextMailbox)
+		if !ok {
+			t.Skip("Backend doesn't supports cancellation of SearchMessages (need SearchContextMailbox interface)")
+			t.SkipNow()
+		}
+
+		// Criteria requires body of each message to be checked.
+		criteria := &imap.SearchCriteria{Text: []string{"this text is not in messages"}}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		res, err := smbox.SearchMessagesContext(ctx, false, criteria)
+		checkCancelled(t, err)
+		assert.Check(t, is.Len(res, 0), "Results are returned for cancelled context")
+
+		// Context is cancelled when backend checks it for the second time,
+		// i.e. after the check before search is started. Search may still
+		// complete if backend doesn't check context while messages are
+		// matched, in this case result should be correct.
+		ctx, cancel = context.WithCancel(context.Background())
+		defer cancel()
+		cctx := &cancelingContext{Context: ctx, cancel: cancel, cancelAfter: 2}
+		done := make(chan error, 1)
+		go func() {
+			var err error
+			res, err = smbox.SearchMessagesContext(cctx, false, criteria)
+			done <- err
+		}()
+		if err := waitReturn(t, done, "SearchMessagesContext"); err != nil {
+			checkCancelled(t, err)
+		} else {
+			assert.Check(t, is.Len(res, 0), "Search completed with wrong results")
+		}
+	})
+	t.Run("CreateMessage", func(t *testing.T) {
+		skipIfExcluded(t)
+
+		cmbox, ok := mbox.(CreateContextMailbox)
+		if !ok {
+			t.Skip("Backend doesn't supports cancellation of CreateMessage (need CreateContextMailbox interface)")
+			t.SkipNow()
+		}
+
+		oldStatus, err := mbox.Status([]imap.StatusItem{imap.StatusMessages, imap.StatusUidNext})
+		assert.NilError(t, err)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		checkCancelled(t, cmbox.CreateMessageContext(ctx, []string{imap.SeenFlag}, time.Now(), strings.NewReader(testMailString)))
+
+		// Context is cancelled while message body is read.
+		ctx, cancel = context.WithCancel(context.Background())
+		defer cancel()
+		body := &cancelingLiteral{Reader: strings.NewReader(testMailString), cancel: cancel, cancelAfter: len(testMailString) / 2}
+		done := make(chan error, 1)
+		go func() {
+			done <- cmbox.CreateMessageContext(ctx, []string{imap.SeenFlag}, time.Now(), body)
+		}()
+		checkCancelled(t, waitReturn(t, done, "CreateMessageContext"))
+
+		status, err := mbox.Status([]imap.StatusItem{imap.StatusMessages})
+		assert.NilError(t, err)
+		assert.Check(t, is.Equal(status.Messages, oldStatus.Messages), "Message is created after context cancellation")
+
+		// Mailbox should be usable after cancelled operation, UID of
+		// the next message may be greater than UIDNEXT before
+		// cancellation but should not be less.
+		assert.NilError(t, mbox.CreateMessage([]string{}, time.Now(), strings.NewReader(testMailString)))
+		seq, _ := imap.ParseSeqSet("*")
+		ch := make(chan *imap.Message, 1)
+		assert.NilError(t, mbox.ListMessages(false, seq, []imap.FetchItem{imap.FetchUid, imap.FetchFlags}, ch))
+		msg := <-ch
+		assert.Assert(t, msg != nil, "Message is not created")
+		assert.Check(t, msg.Uid >= oldStatus.UidNext, "UID of new message is less than UIDNEXT")
+		assert.Check(t, !hasAttr(msg.Flags, imap.SeenFlag), "Flags from cancelled operation are applied to the next message")
+	})
+}
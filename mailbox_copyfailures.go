@@ -0,0 +1,215 @@
+package backendtests
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap"
+	move "github.com/emersion/go-imap-move"
+	"github.com/emersion/go-imap/backend"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+// copyDeleteRuns is the amount of times COPY is raced with removal of
+// destination mailbox.
+const copyDeleteRuns = 10
+
+type copyFunc func(src backend.Mailbox, uid bool, seq *imap.SeqSet, dest string) error
+
+func copyMessages(src backend.Mailbox, uid bool, seq *imap.SeqSet, dest string) error {
+	return src.CopyMessages(uid, seq, dest)
+}
+
+func moveMessages(src backend.Mailbox, uid bool, seq *imap.SeqSet, dest string) error {
+	return src.(move.Mailbox).MoveMessages(uid, seq, dest)
+}
+
+// createSizedMsgs creates messages with bodies of specified sizes.
+func createSizedMsgs(t *testing.T, mbox backend.Mailbox, sizes ...int) {
+	t.Helper()
+	for _, size := range sizes {
+		assert.NilError(t, mbox.CreateMessage([]string{}, time.Now(), strings.NewReader(headerStub+strings.Repeat("A", size))))
+	}
+}
+
+// checkCopyAtomic checks source and destination mailboxes after COPY or MOVE
+// of messages with specified indexes (starting from 0). Failed operation
+// should not change any mailbox, successful one should copy all messages.
+// Appended message should get UID that is not less than UIDNEXT in any
+// case.
+func checkCopyAtomic(t *testing.T, err error, moved bool, src, dst backend.Mailbox, srcBefore, dstBefore faultMailbox, indexes []int) {
+	t.Helper()
+
+	srcAfter := captureFaultMailbox(t, src)
+	dstAfter := captureFaultMailbox(t, dst)
+	if err != nil {
+		t.Logf("Operation failed: %v", err)
+		assert.Check(t, is.DeepEqual(dstAfter.msgs, dstBefore.msgs), "Messages are added to destination mailbox by failed operation")
+		assert.Check(t, is.DeepEqual(srcAfter.uids, srcBefore.uids), "Messages are removed from source mailbox by failed operation")
+		assert.Check(t, is.DeepEqual(srcAfter.msgs, srcBefore.msgs), "Messages in source mailbox are changed by failed operation")
+	} else {
+		expectedDst := append([]string{}, dstBefore.msgs...)
+		copied := make(map[int]bool, len(indexes))
+		for _, i := range indexes {
+			expectedDst = append(expectedDst, srcBefore.msgs[i])
+			copied[i] = true
+		}
+		assert.Check(t, is.DeepEqual(dstAfter.msgs, expectedDst), "Messages are not copied to destination mailbox")
+
+		expectedSrc := []string{}
+		for i, msg := range srcBefore.msgs {
+			if !moved || !copied[i] {
+				expectedSrc = append(expectedSrc, msg)
+			}
+		}
+		assert.Check(t, is.DeepEqual(srcAfter.msgs, expectedSrc), "Wrong messages left in source mailbox")
+	}
+	checkFaultInvariants(t, map[string]faultMailbox{dst.Name(): dstAfter}, map[string]faultMailbox{dst.Name(): dstBefore})
+
+	assert.NilError(t, dst.CreateMessage([]string{}, time.Now(), strings.NewReader(testMailString)))
+	appended := captureFaultMailbox(t, dst)
+	assert.Assert(t, is.Len(appended.uids, len(dstAfter.uids)+1), "Wrong amount of messages after append")
+	uid := appended.uids[len(appended.uids)-1]
+	assert.Check(t, uid >= dstAfter.uidNext, "Appended message got UID %d which is less than UIDNEXT %d", uid, dstAfter.uidNext)
+}
+
+// testCopyFailures checks that COPY or MOVE that fails in the middle of
+// multi-message operation doesn't leave partial results.
+func testCopyFailures(t *testing.T, newBack NewBackFunc, closeBack CloseBackFunc, moved bool, op copyFunc) {
+	b := newBack()
+	defer closeBack(b)
+	u := getUser(t, b)
+	defer assert.NilError(t, u.Logout())
+
+	t.Run("APPENDLIMIT", func(t *testing.T) {
+		skipIfExcluded(t)
+
+		src, dst := getMbox(t, u), getMbox(t, u)
+		mAL, ok := dst.(AppendLimitMbox)
+		if !ok {
+			t.Skip("APPENDLIMIT extension is not implemented (need AppendLimitMbox interface)")
+			t.SkipNow()
+		}
+		createSizedMsgs(t, dst, 100)
+		createSizedMsgs(t, src, 100, 700, 100)
+		lim := uint32(500)
+		assert.NilError(t, mAL.SetMessageLimit(&lim))
+
+		srcBefore, dstBefore := captureFaultMailbox(t, src), captureFaultMailbox(t, dst)
+		seq, _ := imap.ParseSeqSet("1:*")
+		// Backend may allow to copy messages over the limit, but if it
+		// doesn't, no messages should be copied.
+		err := op(src, false, seq, dst.Name())
+
+		assert.NilError(t, mAL.SetMessageLimit(nil))
+		checkCopyAtomic(t, err, moved, src, dst, srcBefore, dstBefore, []int{0, 1, 2})
+	})
+	t.Run("Quota", func(t *testing.T) {
+		skipIfExcluded(t)
+
+		// Separate user is used so quota is not affected by messages
+		// created by other tests.
+		u := getUser(t, b)
+		defer assert.NilError(t, u.Logout())
+		qu, ok := u.(QuotaUser)
+		if !ok {
+			t.Skip("Quota is not implemented (need QuotaUser interface)")
+			t.SkipNow()
+		}
+
+		src, dst := getMbox(t, u), getMbox(t, u)
+		createSizedMsgs(t, src, 400, 400, 400)
+		// Quota allows to store source messages and only half of their
+		// copies.
+		quota := uint64(3*(len(headerStub)+400)) * 3 / 2
+		assert.NilError(t, qu.SetStorageQuota(&quota))
+
+		srcBefore, dstBefore := captureFaultMailbox(t, src), captureFaultMailbox(t, dst)
+		seq, _ := imap.ParseSeqSet("1:*")
+		err := op(src, false, seq, dst.Name())
+		if !moved {
+			assert.Check(t, err != nil, "Operation exceeding quota succeeded")
+		}
+
+		assert.NilError(t, qu.SetStorageQuota(nil))
+		checkCopyAtomic(t, err, moved, src, dst, srcBefore, dstBefore, []int{0, 1, 2})
+	})
+	t.Run("Destination deleted", func(t *testing.T) {
+		skipIfExcluded(t)
+
+		for i := 0; i < copyDeleteRuns; i++ {
+			src, dst := getMbox(t, u), getMbox(t, u)
+			createMsgs(t, src, 20)
+			name := dst.Name()
+			srcBefore := captureFaultMailbox(t, src)
+
+			done := make(chan error, 1)
+			go func() {
+				seq, _ := imap.ParseSeqSet("1:*")
+				done <- op(src, false, seq, name)
+			}()
+			assert.NilError(t, u.DeleteMailbox(name))
+			err := <-done
+
+			// Messages should not be added to deleted mailbox or to new one
+			// with the same name.
+			dst = getNamedMbox(t, u, name)
+			dstAfter := captureFaultMailbox(t, dst)
+			assert.Check(t, is.Len(dstAfter.msgs, 0), "Messages are added to recreated destination mailbox")
+
+			srcAfter := captureFaultMailbox(t, src)
+			if err != nil || !moved {
+				assert.Check(t, is.DeepEqual(srcAfter.msgs, srcBefore.msgs), "Source mailbox is changed (operation error: %v)", err)
+			} else {
+				assert.Check(t, is.Len(srcAfter.msgs, 0), "Messages are left in source mailbox after successful MOVE")
+			}
+		}
+	})
+	t.Run("Out of range", func(t *testing.T) {
+		skipIfExcluded(t)
+
+		for _, uid := range []bool{false, true} {
+			src, dst := getMbox(t, u), getMbox(t, u)
+			createMsgs(t, src, 3)
+			createMsgs(t, dst, 1)
+
+			srcBefore, dstBefore := captureFaultMailbox(t, src), captureFaultMailbox(t, dst)
+			seq := new(imap.SeqSet)
+			if uid {
+				seq.AddNum(srcBefore.uids[1])
+				seq.AddRange(srcBefore.uids[2]+10, srcBefore.uids[2]+20)
+			} else {
+				seq.AddNum(2)
+				seq.AddRange(10, 20)
+			}
+			err := op(src, uid, seq, dst.Name())
+
+			// Operation can either fail or ignore missing messages.
+			checkCopyAtomic(t, err, moved, src, dst, srcBefore, dstBefore, []int{1})
+		}
+	})
+}
+
+// Mailbox_CopyMessages_Failures checks that COPY that fails in the middle
+// doesn't add any messages to destination mailbox.
+func Mailbox_CopyMessages_Failures(t *testing.T, newBack NewBackFunc, closeBack CloseBackFunc) {
+	testCopyFailures(t, newBack, closeBack, false, copyMessages)
+}
+
+// Mailbox_MoveMessages_Failures checks that MOVE that fails in the middle
+// doesn't add any messages to destination mailbox and doesn't remove them
+// from source mailbox.
+func Mailbox_MoveMessages_Failures(t *testing.T, newBack NewBackFunc, closeBack CloseBackFunc) {
+	b := newBack()
+	u := getUser(t, b)
+	_, ok := getMbox(t, u).(move.Mailbox)
+	closeBack(b)
+	if !ok {
+		t.Skip("MOVE extension is not implemented (need move.Mailbox extension)")
+		t.SkipNow()
+	}
+
+	testCopyFailures(t, newBack, closeBack, true, moveMessages)
+}
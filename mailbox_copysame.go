@@ -0,0 +1,136 @@
+package backendtests
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/backend"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+// copyAppendMsgs is the amount of messages appended while messages are
+// copied to the same mailbox.
+const copyAppendMsgs = 20
+
+// Mailbox_CopyMessages_SameMailbox checks COPY with source mailbox used as
+// destination.
+func Mailbox_CopyMessages_SameMailbox(t *testing.T, newBack NewBackFunc, closeBack CloseBackFunc) {
+	b := newBack()
+	defer closeBack(b)
+	u := getUser(t, b)
+	defer assert.NilError(t, u.Logout())
+
+	t.Run("Copy", func(t *testing.T) {
+		skipIfExcluded(t)
+
+		mbox := getMbox(t, u)
+		createMsgs(t, mbox, 3)
+		before := captureFaultMailbox(t, mbox)
+
+		seq, _ := imap.ParseSeqSet("2:3")
+		assert.NilError(t, mbox.CopyMessages(false, seq, mbox.Name()))
+
+		after := captureFaultMailbox(t, mbox)
+		assert.Assert(t, is.Len(after.uids, 5), "Wrong amount of messages after COPY to the same mailbox")
+		assert.Check(t, is.DeepEqual(after.uids[:3], before.uids), "UIDs of original messages are changed")
+		assert.Check(t, is.DeepEqual(after.msgs, append(append([]string{}, before.msgs...), before.msgs[1:3]...)), "Original messages are changed or copies are not the same")
+		for i, uid := range after.uids[3:] {
+			assert.Check(t, uid >= before.uidNext, "Copy %d got UID %d less than UIDNEXT before COPY (%d)", i+1, uid, before.uidNext)
+		}
+		checkFaultInvariants(t, map[string]faultMailbox{mbox.Name(): after}, map[string]faultMailbox{mbox.Name(): before})
+
+		status, err := mbox.Status([]imap.StatusItem{imap.StatusMessages, imap.StatusRecent, imap.StatusUidNext})
+		assert.NilError(t, err)
+		assert.Check(t, is.Equal(status.Messages, uint32(5)), "Wrong MESSAGES")
+		assert.Check(t, is.Equal(status.Recent, uint32(5)), "Wrong RECENT")
+		assert.Check(t, status.UidNext > after.uids[4], "UIDNEXT %d is not greater than UID of last copy %d", status.UidNext, after.uids[4])
+	})
+	t.Run("Updates", func(t *testing.T) {
+		skipIfExcluded(t)
+
+		// New backend is used so there are no updates from other tests.
+		b := newBack()
+		defer closeBack(b)
+		updater, ok := b.(backend.BackendUpdater)
+		if !ok {
+			t.Skip("Backend doesn't supports unilateral updates (need backend.BackendUpdater interface)")
+			t.SkipNow()
+		}
+		upds := updater.Updates()
+
+		u := getUser(t, b)
+		defer assert.NilError(t, u.Logout())
+		mbox := getMbox(t, u)
+		createMsgs(t, mbox, 3)
+		collectUpdates(t, upds)
+
+		seq, _ := imap.ParseSeqSet("1:2")
+		assert.NilError(t, mbox.CopyMessages(false, seq, mbox.Name()))
+
+		upd := readUpdate(t, upds, "MailboxUpdate for mailbox")
+		switch upd := upd.(type) {
+		case *backend.MailboxUpdate:
+			assert.Check(t, is.Equal(upd.Mailbox(), mbox.Name()), "Update is for wrong mailbox")
+			assert.Check(t, is.Equal(upd.Messages, uint32(5)), "Wrong amount of messages in mailbox reported in update")
+			if _, ok := upd.Items[imap.StatusRecent]; ok {
+				assert.Check(t, is.Equal(upd.Recent, uint32(5)), "Wrong amount of recent messages in mailbox reported in update")
+			}
+		default:
+			t.Errorf("Non-mailbox update sent by backend: %#v\n", upd)
+		}
+	})
+	for _, uid := range []bool{false, true} {
+		uid := uid
+		t.Run("Star while appending uid="+strconv.FormatBool(uid), func(t *testing.T) {
+			skipIfExcluded(t)
+
+			// COPY of 1:* should copy each message that exists when
+			// COPY is executed exactly once and should never copy
+			// messages created by itself.
+			mbox := getMbox(t, u)
+			for i := 0; i < 5; i++ {
+				assert.NilError(t, mbox.CreateMessage([]string{"$Orig" + strconv.Itoa(i)}, time.Now(), strings.NewReader(testMailString)))
+			}
+
+			done := make(chan error, 1)
+			go func() {
+				for i := 0; i < copyAppendMsgs; i++ {
+					if err := mbox.CreateMessage([]string{"$Append" + strconv.Itoa(i)}, time.Now(), strings.NewReader(testMailString)); err != nil {
+						done <- err
+						return
+					}
+				}
+				done <- nil
+			}()
+			seq, _ := imap.ParseSeqSet("1:*")
+			assert.NilError(t, mbox.CopyMessages(uid, seq, mbox.Name()))
+			assert.NilError(t, <-done)
+
+			after := captureFaultMailbox(t, mbox)
+			checkFaultInvariants(t, map[string]faultMailbox{mbox.Name(): after}, nil)
+
+			// Messages are distinguished by flags, copy is the second
+			// occurrence of the message.
+			var originals, copies []string
+			seen := make(map[string]int)
+			for _, msg := range after.msgs {
+				seen[msg]++
+				switch seen[msg] {
+				case 1:
+					originals = append(originals, msg)
+				case 2:
+					copies = append(copies, msg)
+				default:
+					t.Errorf("Message copied more than once: %s", msg)
+				}
+			}
+			assert.Assert(t, is.Len(originals, 5+copyAppendMsgs), "Wrong amount of original messages")
+			assert.Assert(t, len(copies) >= 5, "Messages that existed before COPY are not copied (%d copies)", len(copies))
+			assert.Check(t, is.DeepEqual(copies, originals[:len(copies)]), "Copied messages are not the first messages of mailbox")
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package backendtests
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/backend"
+	"github.com/foxcpp/go-imap-backend-tests/corpus"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+// fetchOne returns message with specified sequence number fetched with
+// specified items.
+func fetchOne(t *testing.T, mbox backend.Mailbox, seqNum uint32, items []imap.FetchItem) *imap.Message {
+	t.Helper()
+
+	seq := new(imap.SeqSet)
+	seq.AddNum(seqNum)
+	ch := make(chan *imap.Message, 1)
+	assert.NilError(t, mbox.ListMessages(false, seq, items, ch))
+	assert.Assert(t, is.Len(ch, 1), "Wrong number of messages returned")
+	return <-ch
+}
+
+// Mailbox_ListMessages_Corpus checks ENVELOPE, BODYSTRUCTURE and body
+// sections of messages from corpus package.
+func Mailbox_ListMessages_Corpus(t *testing.T, newBack NewBackFunc, closeBack CloseBackFunc) {
+	b := newBack()
+	defer closeBack(b)
+	u := getUser(t, b)
+	defer assert.NilError(t, u.Logout())
+	mbox := getMbox(t, u)
+
+	msgs, err := corpus.Messages()
+	assert.NilError(t, err)
+	for _, msg := range msgs {
+		assert.NilError(t, mbox.CreateMessage([]string{}, time.Now(), bytes.NewReader(msg.Raw)), "CreateMessage %s", msg.Name)
+	}
+
+	for i, msg := range msgs {
+		msg := msg
+		seqNum := uint32(i + 1)
+
+		t.Run(msg.Name, func(t *testing.T) {
+			t.Run("size", func(t *testing.T) {
+				skipIfExcluded(t)
+
+				res := fetchOne(t, mbox, seqNum, []imap.FetchItem{imap.FetchRFC822Size})
+				assert.Check(t, is.Equal(res.Size, uint32(len(msg.Raw))), "Wrong RFC822.SIZE")
+			})
+			t.Run("envelope", func(t *testing.T) {
+				skipIfExcluded(t)
+
+				res := fetchOne(t, mbox, seqNum, []imap.FetchItem{imap.FetchEnvelope})
+				checkEnvelope(t, res.Envelope, msg.Envelope)
+			})
+			t.Run("bodystructure", func(t *testing.T) {
+				skipIfExcluded(t)
+
+				res := fetchOne(t, mbox, seqNum, []imap.FetchItem{imap.FetchBodyStructure})
+				// go-imap server crashes if requested BODYSTRUCTURE is nil.
+				assert.Assert(t, res.BodyStructure != nil, "BODYSTRUCTURE is not returned")
+				if msg.BodyStructure == nil {
+					// Body structure of malformed message is not defined.
+					return
+				}
+				checkBodyStructure(t, res.BodyStructure, msg.BodyStructure)
+			})
+			t.Run("sections", func(t *testing.T) {
+				skipIfExcluded(t)
+
+				for _, name := range msg.SectionNames() {
+					res := fetchOne(t, mbox, seqNum, []imap.FetchItem{imap.FetchItem("BODY.PEEK[" + name + "]")})
+					// Only one section is requested.
+					var lit imap.Literal
+					for _, l := range res.Body {
+						lit = l
+					}
+					if !assert.Check(t, lit != nil, "BODY[%s] is not returned", name) {
+						continue
+					}
+					body, err := ioutil.ReadAll(lit)
+					assert.NilError(t, err)
+					assert.Check(t, is.Equal(string(body), string(msg.Sections[name])), "Wrong BODY[%s]", name)
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,288 @@
+package backendtests
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/textproto"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/backend/backendutil"
+	"github.com/emersion/go-message"
+	"github.com/foxcpp/go-imap-backend-tests/corpus"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+// Differential tests compare results returned by backend for messages from
+// corpus with results computed by go-imap backendutil package, so backends
+// with custom indexes for FETCH and SEARCH are checked without hand-written
+// expectations. Items backendutil fails to compute for a message are not
+// checked, neither are BODYSTRUCTURE fields it never computes (comparison is
+// done after normalizeBodyStructure, as for corpus golden files without
+// fields added by hand).
+
+// diffDate returns internal date of n-th (starting at 0) message. Messages
+// are created every other day so SINCE and BEFORE with even days don't
+// depend on rounding done by backendutil.
+func diffDate(n int) time.Time {
+	return time.Date(2019, time.March, 1+2*n, 6, 0, 0, 0, time.UTC)
+}
+
+// diffDay returns date used in SINCE and BEFORE, it is between dates of
+// n-1-th and n-th messages.
+func diffDay(n int) time.Time {
+	return time.Date(2019, time.March, 2*n, 0, 0, 0, 0, time.UTC)
+}
+
+// diffFlags returns flags of n-th (starting at 0) message.
+func diffFlags(n int) []string {
+	flags := []string{}
+	if n%2 == 0 {
+		flags = append(flags, imap.SeenFlag)
+	}
+	if n%3 == 0 {
+		flags = append(flags, imap.FlaggedFlag)
+	}
+	if n%5 == 0 {
+		flags = append(flags, "$Label1")
+	}
+	return flags
+}
+
+// diffItems returns body sections fetched for message with specified body
+// structure.
+func diffItems(bs *imap.BodyStructure) []imap.FetchItem {
+	items := []imap.FetchItem{
+		"BODY.PEEK[]",
+		"BODY.PEEK[HEADER]",
+		"BODY.PEEK[TEXT]",
+		"BODY.PEEK[HEADER.FIELDS (From To Subject Date Message-Id Content-Type)]",
+		"BODY.PEEK[HEADER.FIELDS.NOT (From To Subject Date Message-Id Content-Type)]",
+		"BODY.PEEK[HEADER.FIELDS (X-Not-Present)]",
+		"BODY.PEEK[]<0.1>",
+		"BODY.PEEK[]<0.100>",
+		"BODY.PEEK[]<10.50>",
+		"BODY.PEEK[]<1000000.10>",
+		"BODY.PEEK[TEXT]<0.30>",
+		"BODY.PEEK[TEXT]<5.1000>",
+	}
+	if bs != nil {
+		items = appendPartItems(items, "", bs)
+	}
+	return items
+}
+
+// appendPartItems appends N and N.MIME sections of all parts of bs to items.
+func appendPartItems(items []imap.FetchItem, prefix string, bs *imap.BodyStructure) []imap.FetchItem {
+	for i, part := range bs.Parts {
+		name := strconv.Itoa(i + 1)
+		if prefix != "" {
+			name = prefix + "." + name
+		}
+		items = append(items, imap.FetchItem("BODY.PEEK["+name+"]"), imap.FetchItem("BODY.PEEK["+name+".MIME]"))
+		items = appendPartItems(items, name, part)
+	}
+	return items
+}
+
+// diffCriteria is the list of search criteria checked by differential tests.
+// LARGER, SMALLER, SENTBEFORE, SENTSINCE and TEXT are not included since
+// backendutil implements them differently from RFC 3501.
+func diffCriteria(uids []uint32) []struct {
+	name     string
+	criteria *imap.SearchCriteria
+} {
+	seqRange := new(imap.SeqSet)
+	seqRange.AddRange(2, 5)
+	uidRange := new(imap.SeqSet)
+	uidRange.AddRange(uids[len(uids)/2], 0)
+
+	return []struct {
+		name     string
+		criteria *imap.SearchCriteria
+	}{
+		{"ALL", &imap.SearchCriteria{}},
+		{"HEADER From \"\"", &imap.SearchCriteria{Header: textproto.MIMEHeader{"From": {""}}}},
+		{"HEADER Cc \"\"", &imap.SearchCriteria{Header: textproto.MIMEHeader{"Cc": {""}}}},
+		{"HEADER Message-Id \"\"", &imap.SearchCriteria{Header: textproto.MIMEHeader{"Message-Id": {""}}}},
+		{"HEADER Content-Type multipart", &imap.SearchCriteria{Header: textproto.MIMEHeader{"Content-Type": {"multipart"}}}},
+		{"HEADER From example", &imap.SearchCriteria{Header: textproto.MIMEHeader{"From": {"example"}}}},
+		{"HEADER X-Not-Present \"\"", &imap.SearchCriteria{Header: textproto.MIMEHeader{"X-Not-Present": {""}}}},
+		{"BODY text", &imap.SearchCriteria{Body: []string{"text"}}},
+		{"BODY html", &imap.SearchCriteria{Body: []string{"html"}}},
+		{"BODY this text is not in messages", &imap.SearchCriteria{Body: []string{"this text is not in messages"}}},
+		{"SEEN", &imap.SearchCriteria{WithFlags: []string{imap.SeenFlag}}},
+		{"UNFLAGGED", &imap.SearchCriteria{WithoutFlags: []string{imap.FlaggedFlag}}},
+		{"KEYWORD $Label1", &imap.SearchCriteria{WithFlags: []string{"$Label1"}}},
+		{"SEEN FLAGGED", &imap.SearchCriteria{WithFlags: []string{imap.SeenFlag, imap.FlaggedFlag}}},
+		{"2:5", &imap.SearchCriteria{SeqNum: seqRange}},
+		{"UID " + uidRange.String(), &imap.SearchCriteria{Uid: uidRange}},
+		{"SINCE", &imap.SearchCriteria{Since: diffDay(5)}},
+		{"BEFORE", &imap.SearchCriteria{Before: diffDay(10)}},
+		{"SINCE BEFORE", &imap.SearchCriteria{Since: diffDay(3), Before: diffDay(7)}},
+		{"NOT HEADER Content-Type multipart", &imap.SearchCriteria{
+			Not: []*imap.SearchCriteria{{Header: textproto.MIMEHeader{"Content-Type": {"multipart"}}}},
+		}},
+		{"OR FLAGGED BODY text", &imap.SearchCriteria{
+			Or: [][2]*imap.SearchCriteria{{
+				{WithFlags: []string{imap.FlaggedFlag}},
+				{Body: []string{"text"}},
+			}},
+		}},
+		{"SEEN BODY text", &imap.SearchCriteria{WithFlags: []string{imap.SeenFlag}, Body: []string{"text"}}},
+	}
+}
+
+func Mailbox_Differential(t *testing.T, newBack NewBackFunc, closeBack CloseBackFunc) {
+	b := newBack()
+	defer closeBack(b)
+	u := getUser(t, b)
+	defer assert.NilError(t, u.Logout())
+	mbox := getMbox(t, u)
+
+	msgs, err := corpus.Messages()
+	assert.NilError(t, err)
+	for i, msg := range msgs {
+		assert.NilError(t, mbox.CreateMessage(diffFlags(i), diffDate(i), bytes.NewReader(msg.Raw)), "CreateMessage %s", msg.Name)
+	}
+
+	seq, _ := imap.ParseSeqSet("1:*")
+	_, uids := listScale(t, mbox, false, seq)
+	assert.Assert(t, is.Len(uids, len(msgs)), "Wrong amount of messages in mailbox")
+
+	t.Run("fetch", func(t *testing.T) {
+		for i, msg := range msgs {
+			msg := msg
+			seqNum := uint32(i + 1)
+
+			t.Run(msg.Name, func(t *testing.T) {
+				t.Run("envelope", func(t *testing.T) {
+					skipIfExcluded(t)
+
+					expected, err := corpus.ReferenceEnvelope(msg.Raw)
+					if err != nil {
+						t.Skip("No reference ENVELOPE:", err)
+						t.SkipNow()
+					}
+					res := fetchOne(t, mbox, seqNum, []imap.FetchItem{imap.FetchEnvelope})
+					checkEnvelope(t, res.Envelope, expected)
+				})
+				t.Run("bodystructure", func(t *testing.T) {
+					skipIfExcluded(t)
+
+					expected, err := corpus.ReferenceBodyStructure(msg.Raw)
+					if err != nil {
+						t.Skip("No reference BODYSTRUCTURE:", err)
+						t.SkipNow()
+					}
+					res := fetchOne(t, mbox, seqNum, []imap.FetchItem{imap.FetchBodyStructure})
+					checkBodyStructure(t, res.BodyStructure, expected)
+				})
+				t.Run("sections", func(t *testing.T) {
+					skipIfExcluded(t)
+
+					bs, _ := corpus.ReferenceBodyStructure(msg.Raw)
+					for _, item := range diffItems(bs) {
+						section, err := imap.ParseBodySectionName(item)
+						assert.NilError(t, err)
+						expected, err := corpus.ReferenceSection(msg.Raw, section)
+						if err != nil {
+							continue
+						}
+
+						res := fetchOne(t, mbox, seqNum, []imap.FetchItem{item})
+						// Only one section is requested.
+						var lit imap.Literal
+						for _, l := range res.Body {
+							lit = l
+						}
+						if !assert.Check(t, lit != nil, "%s is not returned", item) {
+							continue
+						}
+						body, err := ioutil.ReadAll(lit)
+						assert.NilError(t, err)
+						assert.Check(t, is.Equal(string(body), string(expected)), "Wrong %s", item)
+					}
+				})
+			})
+		}
+	})
+
+	// Parsed messages used by backendutil.Match, nil if message can't be
+	// parsed.
+	entities := make([]*message.Entity, len(msgs))
+	for i, msg := range msgs {
+		e, err := message.Read(bytes.NewReader(msg.Raw))
+		if err == nil {
+			entities[i] = e
+		}
+	}
+
+	t.Run("search", func(t *testing.T) {
+		for _, search := range diffCriteria(uids) {
+			search := search
+
+			t.Run(search.name, func(t *testing.T) {
+				skipIfExcluded(t)
+
+				// Messages backendutil fails to match are excluded from
+				// both results.
+				undefined := make(map[uint32]bool)
+				var expectedSeq, expectedUid []uint32
+				for i, msg := range msgs {
+					seqNum := uint32(i + 1)
+					if entities[i] == nil {
+						undefined[seqNum] = true
+						continue
+					}
+					// Entity body is consumed by Match.
+					e, _ := message.Read(bytes.NewReader(msg.Raw))
+					ok, err := backendutil.Match(e, seqNum, uids[i], diffDate(i), diffFlags(i), search.criteria)
+					if err != nil {
+						undefined[seqNum] = true
+						continue
+					}
+					if ok {
+						expectedSeq = append(expectedSeq, seqNum)
+						expectedUid = append(expectedUid, uids[i])
+					}
+				}
+
+				res, err := mbox.SearchMessages(false, search.criteria)
+				assert.NilError(t, err)
+				res = withoutUndefined(res, undefined, nil)
+				assert.Check(t, is.DeepEqual(res, expectedSeq), "Wrong result of SEARCH %s", search.name)
+
+				res, err = mbox.SearchMessages(true, search.criteria)
+				assert.NilError(t, err)
+				res = withoutUndefined(res, undefined, uids)
+				assert.Check(t, is.DeepEqual(res, expectedUid), "Wrong result of UID SEARCH %s", search.name)
+			})
+		}
+	})
+}
+
+// withoutUndefined removes messages with sequence numbers from undefined from
+// search results, uids is used to map UIDs to sequence numbers if results
+// contain UIDs.
+func withoutUndefined(res []uint32, undefined map[uint32]bool, uids []uint32) []uint32 {
+	var filtered []uint32
+	for _, id := range res {
+		seqNum := id
+		if uids != nil {
+			seqNum = 0
+			for i, uid := range uids {
+				if uid == id {
+					seqNum = uint32(i + 1)
+				}
+			}
+		}
+		if !undefined[seqNum] {
+			filtered = append(filtered, id)
+		}
+	}
+	return filtered
+}
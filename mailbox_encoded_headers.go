@@ -37,6 +37,8 @@ func Mailbox_FetchEncoded(t *testing.T, newBack NewBackFunc, closeBack CloseBack
 	seq, _ := imap.ParseSeqSet("1")
 
 	t.Run("envelope", func(t *testing.T) {
+		skipIfExcluded(t)
+
 		// https://tools.ietf.org/html/rfc3501#section-2.3.5
 		// >A parsed representation of the [RFC-2822] header of the message.
 		// It refers to RFC-2822 header, not MIME, meaning that it fields should
@@ -50,6 +52,8 @@ func Mailbox_FetchEncoded(t *testing.T, newBack NewBackFunc, closeBack CloseBack
 		assert.Equal(t, msg.Envelope.Subject, "=?utf-8?B?0J/RgNC+0LLQtdGA0LrQsCE=?=", "Subject field value is different (decoded?)")
 	})
 	t.Run("header subset", func(t *testing.T) {
+		skipIfExcluded(t)
+
 		ch := make(chan *imap.Message, 1)
 		assert.NilError(t, mbox.ListMessages(false, seq, []imap.FetchItem{imap.FetchItem("BODY.PEEK[HEADER.FIELDS (From Subject)]")}, ch))
 		msg := <-ch
@@ -69,6 +73,8 @@ func Mailbox_FetchEncoded(t *testing.T, newBack NewBackFunc, closeBack CloseBack
 		assert.Check(t, strings.Contains(string(bodyBlob), `Subject: =?utf-8?B?0J/RgNC+0LLQtdGA0LrQsCE=?=`), "Missing or different Subject field")
 	})
 	t.Run("body subset", func(t *testing.T) {
+		skipIfExcluded(t)
+
 		ch := make(chan *imap.Message, 1)
 		assert.NilError(t, mbox.ListMessages(false, seq, []imap.FetchItem{imap.FetchItem("BODY.PEEK[]<360.2>")}, ch))
 		msg := <-ch
@@ -95,6 +101,8 @@ func Mailbox_MatchEncoded(t *testing.T, newBack NewBackFunc, closeBack CloseBack
 	assert.NilError(t, mbox.CreateMessage([]string{}, time.Now(), strings.NewReader(encodedTestMsg)))
 
 	t.Run("header", func(t *testing.T) {
+		skipIfExcluded(t)
+
 		crit := imap.SearchCriteria{
 			Header: textproto.MIMEHeader{"Subject": []string{"Проверка!"}},
 		}
@@ -103,6 +111,8 @@ func Mailbox_MatchEncoded(t *testing.T, newBack NewBackFunc, closeBack CloseBack
 		assert.Equal(t, len(seqs), 1, "Not matched against decoded value")
 	})
 	t.Run("body", func(t *testing.T) {
+		skipIfExcluded(t)
+
 		crit := imap.SearchCriteria{
 			Text: []string{"или"},
 		}
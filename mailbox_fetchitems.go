@@ -1,6 +1,7 @@
 package backendtests
 
 import (
+	"bytes"
 	"io/ioutil"
 	"strings"
 	"testing"
@@ -251,83 +252,6 @@ func Mailbox_ListMessages_Body(t *testing.T, newBack NewBackFunc, closeBack Clos
 	}
 }
 
-var testBodyStructure = &imap.BodyStructure{
-	MIMEType:    "multipart",
-	MIMESubType: "mixed",
-	Params:      map[string]string{"boundary": "message-boundary"},
-	Parts: []*imap.BodyStructure{
-		{
-			MIMEType:    "multipart",
-			MIMESubType: "alternative",
-			Params:      map[string]string{"boundary": "b2"},
-			Extended:    true,
-			Parts: []*imap.BodyStructure{
-				{
-					MIMEType:          "text",
-					MIMESubType:       "plain",
-					Params:            map[string]string{},
-					Extended:          true,
-					Disposition:       "inline",
-					DispositionParams: map[string]string{},
-				},
-				{
-					MIMEType:          "text",
-					MIMESubType:       "html",
-					Params:            map[string]string{},
-					Extended:          true,
-					Disposition:       "inline",
-					DispositionParams: map[string]string{},
-				},
-			},
-		},
-		{
-			MIMEType:          "text",
-			MIMESubType:       "plain",
-			Params:            map[string]string{},
-			Extended:          true,
-			Disposition:       "attachment",
-			DispositionParams: map[string]string{"filename": "note.txt"},
-		},
-	},
-	Extended: true,
-}
-
-var testEnvelope = &imap.Envelope{
-	Date: testDate,
-	From: []*imap.Address{
-		{
-			PersonalName: "Mitsuha Miyamizu",
-			MailboxName:  "mitsuha.miyamizu",
-			HostName:     "example.org",
-		},
-	},
-	To: []*imap.Address{
-		{
-			PersonalName: "Taki Tachibana",
-			MailboxName:  "taki.tachibana",
-			HostName:     "example.org",
-		},
-	},
-	Subject:   "Your Name.",
-	MessageId: "42@example.org",
-	Sender: []*imap.Address{
-		{
-			PersonalName: "Mitsuha Miyamizu",
-			MailboxName:  "mitsuha.miyamizu",
-			HostName:     "example.org",
-		},
-	},
-	Cc:  []*imap.Address{},
-	Bcc: []*imap.Address{},
-	ReplyTo: []*imap.Address{
-		{
-			PersonalName: "Mitsuha Miyamizu",
-			MailboxName:  "mitsuha.miyamizu",
-			HostName:     "example.org",
-		},
-	},
-}
-
 func stripEnvelope(env *imap.Envelope) {
 	if env.From == nil {
 		env.From = []*imap.Address{}
@@ -377,7 +301,11 @@ func Mailbox_ListMessages_Meta(t *testing.T, newBack NewBackFunc, closeBack Clos
 	u := getUser(t, b)
 	defer assert.NilError(t, u.Logout())
 	mbox := getMbox(t, u)
-	createMsgs(t, mbox, 1)
+
+	// Message is the same as testMailString, expected results are stored in
+	// corpus.
+	golden := goldenMessage(t, "test-mail")
+	assert.NilError(t, mbox.CreateMessage([]string{}, time.Now(), bytes.NewReader(golden.Raw)))
 
 	t.Run("fetch bodystruct", func(t *testing.T) {
 		skipIfExcluded(t)
@@ -389,9 +317,7 @@ func Mailbox_ListMessages_Meta(t *testing.T, newBack NewBackFunc, closeBack Clos
 		msg := <-ch
 		assert.Equal(t, msg.SeqNum, uint32(1))
 
-		stripBodyStructure(msg.BodyStructure)
-		stripBodyStructure(testBodyStructure)
-		assert.DeepEqual(t, msg.BodyStructure, testBodyStructure)
+		checkBodyStructure(t, msg.BodyStructure, golden.BodyStructure)
 	})
 
 	t.Run("fetch envelope", func(t *testing.T) {
@@ -403,9 +329,7 @@ func Mailbox_ListMessages_Meta(t *testing.T, newBack NewBackFunc, closeBack Clos
 		assert.Assert(t, is.Len(ch, 1), "Wrong number of messages returned")
 		msg := <-ch
 
-		stripEnvelope(msg.Envelope)
-		stripEnvelope(testEnvelope)
-		assert.DeepEqual(t, msg.Envelope, testEnvelope)
+		checkEnvelope(t, msg.Envelope, golden.Envelope)
 	})
 }
 
@@ -0,0 +1,188 @@
+package backendtests
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap"
+	move "github.com/emersion/go-imap-move"
+	"github.com/emersion/go-imap/backend"
+	"github.com/google/go-cmp/cmp"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+// Mailbox_MoveMessages_Updates checks that MOVE of non-contiguous set of
+// messages sends ExpungeUpdate for each moved message in source mailbox
+// (sequence numbers are relative to the state after previous updates) and
+// MailboxUpdate for destination mailbox.
+func Mailbox_MoveMessages_Updates(t *testing.T, newBack NewBackFunc, closeBack CloseBackFunc) {
+	b := newBack()
+	defer closeBack(b)
+
+	updater, ok := b.(backend.BackendUpdater)
+	if !ok {
+		t.Skip("Backend doesn't supports unilateral updates (need backend.BackendUpdater interface)")
+		t.SkipNow()
+	}
+	upds := updater.Updates()
+
+	u := getUser(t, b)
+	defer assert.NilError(t, u.Logout())
+
+	srcMbox, tgtMbox := getMbox(t, u), getMbox(t, u)
+	moveMbox, ok := srcMbox.(move.Mailbox)
+	if !ok {
+		t.Skip("MOVE extension is not implemented (need move.Mailbox extension)")
+		t.SkipNow()
+	}
+
+	createMsgs(t, srcMbox, 5)
+	createMsgs(t, tgtMbox, 1)
+	collectUpdates(t, upds)
+
+	seq, _ := imap.ParseSeqSet("2,4:5")
+	assert.NilError(t, moveMbox.MoveMessages(false, seq, tgtMbox.Name()))
+
+	// 3 ExpungeUpdates for source mailbox and 1 MailboxUpdate for target.
+	slots := makeMsgSlots(5)
+	mboxUpdates := 0
+	for i := 0; i < 4; i++ {
+		upd := readUpdate(t, upds, fmt.Sprintf("update %d of 4 (1 MailboxUpdate and 3 ExpungeUpdates)", i+1))
+		switch upd.Mailbox() {
+		case tgtMbox.Name():
+			mboxUpd, ok := upd.(*backend.MailboxUpdate)
+			if !ok {
+				t.Fatalf("Non-MailboxUpdate received for target mailbox: %#v", upd)
+			}
+			mboxUpdates++
+			assert.Check(t, is.Equal(mboxUpd.Messages, uint32(4)), "Wrong amount of messages in mailbox reported in update for target")
+		case srcMbox.Name():
+			expungeUpd, ok := upd.(*backend.ExpungeUpdate)
+			if !ok {
+				t.Fatalf("Non-ExpungeUpdate received for source mailbox: %#v", upd)
+			}
+			if expungeUpd.SeqNum == 0 || expungeUpd.SeqNum > uint32(len(slots)) {
+				t.Fatalf("ExpungeUpdate's SeqNum is out of range: %v (%d messages left)", expungeUpd.SeqNum, len(slots))
+			}
+			slots = append(slots[:expungeUpd.SeqNum-1], slots[expungeUpd.SeqNum:]...)
+		default:
+			t.Fatalf("Update for unrelated mailbox received: %#v", upd)
+		}
+	}
+	assert.Check(t, is.Equal(mboxUpdates, 1), "Wrong amount of MailboxUpdates for target mailbox")
+	assert.Check(t, is.DeepEqual(slots, []uint32{1, 3}), "ExpungeUpdates removed wrong messages")
+}
+
+// Mailbox_MoveMessages_Semantics checks RFC 6851 requirements for MOVE that
+// are not covered by Mailbox_MoveMessages.
+func Mailbox_MoveMessages_Semantics(t *testing.T, newBack NewBackFunc, closeBack CloseBackFunc) {
+	b := newBack()
+	defer closeBack(b)
+	u := getUser(t, b)
+	defer assert.NilError(t, u.Logout())
+
+	if _, ok := getMbox(t, u).(move.Mailbox); !ok {
+		t.Skip("MOVE extension is not implemented (need move.Mailbox extension)")
+		t.SkipNow()
+	}
+
+	// createFlaggedMsgs creates messages with specified flags and returns
+	// state of mailbox.
+	createFlaggedMsgs := func(t *testing.T, mbox backend.Mailbox, flags ...[]string) faultMailbox {
+		t.Helper()
+		for i, f := range flags {
+			assert.NilError(t, mbox.CreateMessage(f, baseDate.Add(time.Duration(i+1)*24*time.Hour), strings.NewReader(testMailString)))
+		}
+		return captureFaultMailbox(t, mbox)
+	}
+
+	t.Run("UIDs", func(t *testing.T) {
+		skipIfExcluded(t)
+
+		srcMbox, tgtMbox := getMbox(t, u), getMbox(t, u)
+		createMsgs(t, srcMbox, 5)
+		createMsgs(t, tgtMbox, 2)
+		srcBefore, tgtBefore := captureFaultMailbox(t, srcMbox), captureFaultMailbox(t, tgtMbox)
+
+		seq, _ := imap.ParseSeqSet("2,4:5")
+		assert.NilError(t, srcMbox.(move.Mailbox).MoveMessages(false, seq, tgtMbox.Name()))
+
+		srcAfter, tgtAfter := captureFaultMailbox(t, srcMbox), captureFaultMailbox(t, tgtMbox)
+		assert.Check(t, is.DeepEqual(srcAfter.uids, []uint32{srcBefore.uids[0], srcBefore.uids[2]}), "UIDs of messages left in source mailbox are changed")
+
+		assert.Assert(t, is.Len(tgtAfter.uids, 5), "Wrong amount of messages in target mailbox")
+		assert.Check(t, is.DeepEqual(tgtAfter.uids[:2], tgtBefore.uids), "UIDs of existing messages in target mailbox are changed")
+		assert.Check(t, is.DeepEqual(tgtAfter.msgs[2:], []string{srcBefore.msgs[1], srcBefore.msgs[3], srcBefore.msgs[4]}), "Moved messages are reordered or changed")
+		for i, uid := range tgtAfter.uids[2:] {
+			assert.Check(t, uid >= tgtBefore.uidNext, "Moved message %d got UID %d less than UIDNEXT before MOVE (%d)", i+1, uid, tgtBefore.uidNext)
+		}
+		checkFaultInvariants(t, map[string]faultMailbox{tgtMbox.Name(): tgtAfter}, map[string]faultMailbox{tgtMbox.Name(): tgtBefore})
+	})
+	t.Run("Deleted flag", func(t *testing.T) {
+		skipIfExcluded(t)
+
+		// MOVE must not set \Deleted (RFC 6851, section 3.3), but
+		// \Deleted set before should be copied as any other flag.
+		// Messages that are not moved should not be expunged even if they
+		// have \Deleted flag.
+		srcMbox, tgtMbox := getMbox(t, u), getMbox(t, u)
+		srcBefore := createFlaggedMsgs(t, srcMbox,
+			[]string{imap.DeletedFlag},
+			[]string{imap.SeenFlag},
+			[]string{imap.DeletedFlag, "$Test"},
+			[]string{imap.DeletedFlag},
+		)
+
+		seq, _ := imap.ParseSeqSet("2:3")
+		assert.NilError(t, srcMbox.(move.Mailbox).MoveMessages(false, seq, tgtMbox.Name()))
+
+		srcAfter, tgtAfter := captureFaultMailbox(t, srcMbox), captureFaultMailbox(t, tgtMbox)
+		assert.Check(t, is.DeepEqual(tgtAfter.msgs, srcBefore.msgs[1:3]), "Flags of moved messages are changed")
+		assert.Check(t, is.DeepEqual(srcAfter.msgs, []string{srcBefore.msgs[0], srcBefore.msgs[3]}), "Messages with \\Deleted flag that are not moved are changed or expunged")
+	})
+	t.Run("Keywords", func(t *testing.T) {
+		skipIfExcluded(t)
+
+		srcMbox, tgtMbox := getMbox(t, u), getMbox(t, u)
+		srcBefore := createFlaggedMsgs(t, srcMbox,
+			[]string{"$Forwarded", "$MDNSent", "NonJunk"},
+			[]string{imap.FlaggedFlag, imap.AnsweredFlag, imap.DraftFlag, "$Label1"},
+			[]string{},
+		)
+
+		seq, _ := imap.ParseSeqSet("1:*")
+		assert.NilError(t, srcMbox.(move.Mailbox).MoveMessages(false, seq, tgtMbox.Name()))
+
+		tgtAfter := captureFaultMailbox(t, tgtMbox)
+		assert.Check(t, is.DeepEqual(tgtAfter.msgs, srcBefore.msgs), "Flags of moved messages are changed")
+	})
+	t.Run("Same mailbox", func(t *testing.T) {
+		skipIfExcluded(t)
+
+		// MOVE to the same mailbox can either fail or assign new UIDs to
+		// moved messages, like COPY + EXPUNGE would.
+		mbox := getMbox(t, u)
+		before := createFlaggedMsgs(t, mbox, []string{"$Test1"}, []string{"$Test2"}, []string{"$Test3"})
+
+		seq, _ := imap.ParseSeqSet("1:2")
+		err := mbox.(move.Mailbox).MoveMessages(false, seq, mbox.Name())
+
+		after := captureFaultMailbox(t, mbox)
+		if err != nil {
+			t.Logf("MOVE to the same mailbox failed: %v", err)
+			assert.Check(t, is.DeepEqual(after, before, cmp.AllowUnexported(faultMailbox{})), "Mailbox is changed by failed MOVE")
+			return
+		}
+
+		assert.Assert(t, is.Len(after.uids, 3), "Wrong amount of messages after MOVE to the same mailbox")
+		assert.Check(t, is.DeepEqual(after.msgs, []string{before.msgs[2], before.msgs[0], before.msgs[1]}), "Wrong messages after MOVE to the same mailbox")
+		assert.Check(t, is.Equal(after.uids[0], before.uids[2]), "UID of message that is not moved is changed")
+		for i, uid := range after.uids[1:] {
+			assert.Check(t, uid >= before.uidNext, "Moved message %d got UID %d less than UIDNEXT before MOVE (%d)", i+1, uid, before.uidNext)
+		}
+		checkFaultInvariants(t, map[string]faultMailbox{mbox.Name(): after}, map[string]faultMailbox{mbox.Name(): before})
+	})
+}
@@ -0,0 +1,284 @@
+package backendtests
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap"
+	move "github.com/emersion/go-imap-move"
+	"github.com/emersion/go-imap/backend"
+	"gotest.tools/assert"
+)
+
+const (
+	// randomOpsRuns is the amount of random sequences executed by
+	// Mailbox_RandomOps, randomOpsCount is the length of each one.
+	randomOpsRuns  = 5
+	randomOpsCount = 40
+
+	randomOpsUser = "randomops"
+)
+
+// randomOpsMailboxes are mailboxes used by random operations, all of them
+// are created before operations are executed.
+var randomOpsMailboxes = []string{"RandomOps1", "RandomOps2", "RandomOps3"}
+
+type randomOpKind int
+
+const (
+	randomAppend randomOpKind = iota
+	randomStore
+	randomCopy
+	randomMove
+	randomExpunge
+)
+
+// randomOp is a single operation. Messages are selected using first and
+// last that are converted to sequence numbers of existing messages when
+// operation is executed, so operations remain valid when sequence is shrunk.
+type randomOp struct {
+	kind        randomOpKind
+	mbox        int
+	target      int
+	uid         bool
+	first, last int
+	flagsOp     imap.FlagsOp
+	flags       []string
+}
+
+// randomOps generates sequence of operations, MOVE is used only if withMove
+// is true.
+func randomOps(r *rand.Rand, count int, withMove bool) []randomOp {
+	ops := make([]randomOp, 0, count)
+	for len(ops) < count {
+		op := randomOp{
+			kind:    randomOpKind(r.Intn(5)),
+			mbox:    r.Intn(len(randomOpsMailboxes)),
+			target:  r.Intn(len(randomOpsMailboxes)),
+			uid:     r.Intn(2) == 0,
+			first:   r.Intn(100),
+			last:    r.Intn(100),
+			flagsOp: []imap.FlagsOp{imap.SetFlags, imap.AddFlags, imap.RemoveFlags}[r.Intn(3)],
+		}
+		for _, flag := range opFlags {
+			if r.Intn(3) == 0 {
+				op.flags = append(op.flags, flag)
+			}
+		}
+		if op.kind == randomMove && (!withMove || op.target == op.mbox) {
+			continue
+		}
+		// Messages are added more often than removed so mailboxes are
+		// not empty most of the time.
+		if op.kind == randomAppend || r.Intn(3) != 0 {
+			ops = append(ops, op)
+		}
+	}
+	return ops
+}
+
+// randomOpsCode is Go code of executed operations.
+type randomOpsCode struct {
+	lines []string
+	// mboxes contains indexes of mailboxes used by lines.
+	mboxes map[int]bool
+	// seq is true if seq variable is used by lines.
+	seq bool
+}
+
+// mbox returns name of variable for mailbox with index indx.
+func (c *randomOpsCode) mbox(indx int) string {
+	if c.mboxes == nil {
+		c.mboxes = make(map[int]bool)
+	}
+	c.mboxes[indx] = true
+	return fmt.Sprintf("mbox%d", indx+1)
+}
+
+// String returns code that can be pasted into test function.
+func (c *randomOpsCode) String() string {
+	var b strings.Builder
+	b.WriteString("b := newBack()\n")
+	b.WriteString("defer closeBack(b)\n")
+	b.WriteString("u := getUser(t, b)\n")
+	b.WriteString("defer assert.NilError(t, u.Logout())\n")
+	for i, name := range randomOpsMailboxes {
+		if c.mboxes[i] {
+			fmt.Fprintf(&b, "mbox%d := getNamedMbox(t, u, %q)\n", i+1, name)
+		}
+	}
+	if c.seq {
+		b.WriteString("var seq *imap.SeqSet\n")
+	}
+	for _, line := range c.lines {
+		b.WriteString(line + "\n")
+	}
+	return b.String()
+}
+
+// randomOpsRun executes operations against backend and model of IMAP
+// semantics.
+type randomOpsRun struct {
+	ops    []randomOp
+	mboxes []backend.Mailbox
+	model  []*modelMailbox
+	code   randomOpsCode
+}
+
+// goFlags returns Go code for list of flags.
+func goFlags(flags []string) string {
+	quoted := make([]string, 0, len(flags))
+	for _, flag := range flags {
+		quoted = append(quoted, fmt.Sprintf("%q", flag))
+	}
+	return "[]string{" + strings.Join(quoted, ", ") + "}"
+}
+
+var goFlagsOps = map[imap.FlagsOp]string{
+	imap.SetFlags:    "imap.SetFlags",
+	imap.AddFlags:    "imap.AddFlags",
+	imap.RemoveFlags: "imap.RemoveFlags",
+}
+
+// runRandomOps executes operations against new backend and model, state of
+// backend is checked after each operation.
+func runRandomOps(newBack NewBackFunc, closeBack CloseBackFunc, ops []randomOp) (*randomOpsCode, error) {
+	run := &randomOpsRun{ops: ops}
+	err := runOps(newBack, closeBack, randomOpsUser, len(ops), run)
+	return &run.code, err
+}
+
+func (r *randomOpsRun) setup(u backend.User) error {
+	for _, name := range randomOpsMailboxes {
+		if err := u.CreateMailbox(name); err != nil {
+			return err
+		}
+		mbox, err := u.GetMailbox(name)
+		if err != nil {
+			return err
+		}
+		status, err := mbox.Status([]imap.StatusItem{imap.StatusUidNext})
+		if err != nil {
+			return err
+		}
+		r.mboxes = append(r.mboxes, mbox)
+		r.model = append(r.model, &modelMailbox{uidNext: status.UidNext, checkRecent: true})
+	}
+	return nil
+}
+
+func (r *randomOpsRun) exec(step int) error {
+	op := &r.ops[step]
+	mbox, model := r.mboxes[op.mbox], r.model[op.mbox]
+
+	switch op.kind {
+	case randomAppend:
+		r.code.lines = append(r.code.lines, fmt.Sprintf("assert.NilError(t, %s.CreateMessage(%s, time.Now(), strings.NewReader(testMailString)))", r.code.mbox(op.mbox), goFlags(op.flags)))
+		if err := mbox.CreateMessage(copyFlags(op.flags), time.Now(), strings.NewReader(testMailString)); err != nil {
+			return fmt.Errorf("CreateMessage: %v", err)
+		}
+		model.append(op.flags, nil)
+		return nil
+	case randomExpunge:
+		r.code.lines = append(r.code.lines, fmt.Sprintf("assert.NilError(t, %s.Expunge())", r.code.mbox(op.mbox)))
+		if err := mbox.Expunge(); err != nil {
+			return fmt.Errorf("Expunge: %v", err)
+		}
+		model.expunge()
+		return nil
+	}
+
+	first, last, ok := model.seqRange(op.first, op.last)
+	if !ok {
+		r.code.lines = append(r.code.lines, fmt.Sprintf("// Operation on empty %s is skipped.", randomOpsMailboxes[op.mbox]))
+		return nil
+	}
+	seq := new(imap.SeqSet)
+	if op.uid {
+		// UIDs of all messages are known after check.
+		seq.AddRange(model.msgs[first-1].uid, model.msgs[last-1].uid)
+	} else {
+		seq.AddRange(first, last)
+	}
+	r.code.lines = append(r.code.lines, fmt.Sprintf("seq, _ = imap.ParseSeqSet(%q)", seq))
+	r.code.seq = true
+
+	switch op.kind {
+	case randomStore:
+		r.code.lines = append(r.code.lines, fmt.Sprintf("assert.NilError(t, %s.UpdateMessagesFlags(%v, seq, %s, %s))", r.code.mbox(op.mbox), op.uid, goFlagsOps[op.flagsOp], goFlags(op.flags)))
+		if err := mbox.UpdateMessagesFlags(op.uid, seq, op.flagsOp, copyFlags(op.flags)); err != nil {
+			return fmt.Errorf("UpdateMessagesFlags: %v", err)
+		}
+		model.updateFlags(first, last, op.flagsOp, op.flags)
+	case randomCopy, randomMove:
+		var err error
+		if op.kind == randomCopy {
+			r.code.lines = append(r.code.lines, fmt.Sprintf("assert.NilError(t, %s.CopyMessages(%v, seq, %s.Name()))", r.code.mbox(op.mbox), op.uid, r.code.mbox(op.target)))
+			err = mbox.CopyMessages(op.uid, seq, r.mboxes[op.target].Name())
+		} else {
+			r.code.lines = append(r.code.lines, fmt.Sprintf("assert.NilError(t, %s.(move.Mailbox).MoveMessages(%v, seq, %s.Name()))", r.code.mbox(op.mbox), op.uid, r.code.mbox(op.target)))
+			err = mbox.(move.Mailbox).MoveMessages(op.uid, seq, r.mboxes[op.target].Name())
+		}
+		if err != nil {
+			return err
+		}
+
+		model.copyTo(r.model[op.target], first, last)
+		if op.kind == randomMove {
+			model.remove(first, last)
+		}
+	}
+	return nil
+}
+
+// check compares all mailboxes with model.
+func (r *randomOpsRun) check() error {
+	for i, mbox := range r.mboxes {
+		if err := r.model[i].check(mbox); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Mailbox_RandomOps executes random sequences of APPEND, STORE, COPY, MOVE
+// and EXPUNGE across several mailboxes and compares sequence numbers, UIDs,
+// flags, \Recent and UIDNEXT with model after each operation. Failed sequence
+// is shrunk and printed as Go code.
+func Mailbox_RandomOps(t *testing.T, newBack NewBackFunc, closeBack CloseBackFunc) {
+	// MOVE is used only if backend supports it.
+	b := newBack()
+	u := getUser(t, b)
+	_, withMove := getMbox(t, u).(move.Mailbox)
+	assert.NilError(t, u.Logout())
+	closeBack(b)
+
+	r := newTestRand(t)
+	for i := 1; i <= randomOpsRuns; i++ {
+		ops := randomOps(r, randomOpsCount, withMove)
+
+		t.Run(fmt.Sprintf("sequence %d", i), func(t *testing.T) {
+			skipIfExcluded(t)
+
+			code, err := runRandomOps(newBack, closeBack, ops)
+			if err == nil {
+				return
+			}
+			steps, err := shrinkSteps(len(ops), err, func(steps []int) error {
+				shrunk := make([]randomOp, 0, len(steps))
+				for _, step := range steps {
+					shrunk = append(shrunk, ops[step])
+				}
+				candCode, candErr := runRandomOps(newBack, closeBack, shrunk)
+				if candErr != nil {
+					code = candCode
+				}
+				return candErr
+			})
+			t.Fatalf("%v\nShrunk to %d operations:\n%s// Check fails: %v", err, len(steps), code, err)
+		})
+	}
+}
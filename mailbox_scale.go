@@ -0,0 +1,232 @@
+package backendtests
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/backend"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+// ScaleMessages is the amount of messages created by Mailbox_Scale. Scale
+// tests are disabled if it is 0. Values between 10000 and 100000 are
+// useful to detect operations with complexity worse than linear.
+var ScaleMessages int
+
+// timeOp executes f and records its duration to Report.
+func timeOp(t *testing.T, operation string, messages int, f func()) {
+	t.Helper()
+
+	start := time.Now()
+	f()
+	d := time.Since(start)
+	t.Logf("%s: %v for %d messages", operation, d.Round(time.Millisecond), messages)
+	if Report != nil {
+		Report.recordTiming(t, operation, messages, d)
+	}
+}
+
+// listScale returns SeqNum and UID of messages returned by ListMessages.
+func listScale(t *testing.T, mbox backend.Mailbox, uid bool, seq *imap.SeqSet) (seqNums, uids []uint32) {
+	t.Helper()
+
+	ch := make(chan *imap.Message, 100)
+	done := make(chan error, 1)
+	go func() {
+		done <- mbox.ListMessages(uid, seq, []imap.FetchItem{imap.FetchUid, imap.FetchFlags}, ch)
+	}()
+	for msg := range ch {
+		seqNums = append(seqNums, msg.SeqNum)
+		uids = append(uids, msg.Uid)
+	}
+	assert.NilError(t, <-done)
+	return
+}
+
+// scaleFlagged returns true if n-th (starting at 0) message created by
+// Mailbox_Scale has \Flagged flag.
+func scaleFlagged(n int) bool {
+	return n%3 == 0
+}
+
+func Mailbox_Scale(t *testing.T, newBack NewBackFunc, closeBack CloseBackFunc) {
+	if ScaleMessages == 0 {
+		t.Skip("Scale tests are disabled (set ScaleMessages)")
+		t.SkipNow()
+	}
+	count := ScaleMessages
+
+	b := newBack()
+	defer closeBack(b)
+
+	// Nobody is interested in updates here, but backend may block if they
+	// are not read.
+	if updater, ok := b.(backend.BackendUpdater); ok {
+		stop := make(chan struct{})
+		defer close(stop)
+		go drainUpdates(updater.Updates(), stop)
+	}
+
+	u := getUser(t, b)
+	defer assert.NilError(t, u.Logout())
+	mbox := getMbox(t, u)
+
+	timeOp(t, "APPEND", count, func() {
+		for i := 0; i < count; i++ {
+			flags := []string{}
+			if scaleFlagged(i) {
+				flags = append(flags, imap.FlaggedFlag)
+			}
+			date := baseDate.Add(time.Duration(i) * time.Minute)
+			assert.NilError(t, mbox.CreateMessage(flags, date, strings.NewReader(testMailString)))
+		}
+	})
+
+	// UIDs of all messages in order of sequence numbers, other subtests
+	// use them so it is not a subtest.
+	var seqNums, uids []uint32
+	seq, _ := imap.ParseSeqSet("1:*")
+	timeOp(t, "FETCH 1:* (UID FLAGS)", count, func() {
+		seqNums, uids = listScale(t, mbox, false, seq)
+	})
+	assert.Assert(t, is.Len(seqNums, count), "Wrong amount of messages returned for 1:*")
+
+	t.Run("FETCH 1:*", func(t *testing.T) {
+		skipIfExcluded(t)
+
+		for i := range seqNums {
+			assert.Assert(t, is.Equal(seqNums[i], uint32(i+1)), "Messages are not returned in order of sequence numbers")
+			if i != 0 {
+				assert.Assert(t, uids[i] > uids[i-1], "UIDs are not increasing: %d after %d", uids[i], uids[i-1])
+			}
+		}
+	})
+	t.Run("FETCH *", func(t *testing.T) {
+		skipIfExcluded(t)
+
+		seq, _ := imap.ParseSeqSet("*")
+		var seqNums, resUids []uint32
+		timeOp(t, "FETCH * (UID FLAGS)", 1, func() {
+			seqNums, resUids = listScale(t, mbox, false, seq)
+		})
+		assert.Assert(t, is.DeepEqual(seqNums, []uint32{uint32(count)}), "Wrong message returned for *")
+		assert.Check(t, is.DeepEqual(resUids, []uint32{uids[count-1]}), "Wrong UID returned for *")
+	})
+	t.Run("FETCH sparse", func(t *testing.T) {
+		skipIfExcluded(t)
+
+		seq := new(imap.SeqSet)
+		var expected []uint32
+		for i := 1; i <= count; i += 997 {
+			seq.AddNum(uint32(i))
+			expected = append(expected, uint32(i))
+		}
+		if expected[len(expected)-1] != uint32(count) {
+			seq.AddNum(uint32(count))
+			expected = append(expected, uint32(count))
+		}
+
+		var seqNums, resUids []uint32
+		timeOp(t, "FETCH sparse (UID FLAGS)", len(expected), func() {
+			seqNums, resUids = listScale(t, mbox, false, seq)
+		})
+		assert.Assert(t, is.DeepEqual(seqNums, expected), "Wrong messages returned for sparse set")
+		for i, seqNum := range seqNums {
+			assert.Check(t, is.Equal(resUids[i], uids[seqNum-1]), "Wrong UID for message %d", seqNum)
+		}
+	})
+	t.Run("UID FETCH range", func(t *testing.T) {
+		skipIfExcluded(t)
+
+		first, last := count/4, count/2
+		seq := new(imap.SeqSet)
+		seq.AddRange(uids[first], uids[last])
+
+		var seqNums, resUids []uint32
+		timeOp(t, "UID FETCH range (UID FLAGS)", last-first+1, func() {
+			seqNums, resUids = listScale(t, mbox, true, seq)
+		})
+		assert.Assert(t, is.Len(seqNums, last-first+1), "Wrong amount of messages returned for UID range")
+		assert.Check(t, is.Equal(seqNums[0], uint32(first+1)), "Wrong sequence number of the first message")
+		assert.Check(t, is.DeepEqual(resUids, uids[first:last+1]), "Wrong UIDs returned for UID range")
+	})
+	t.Run("SEARCH", func(t *testing.T) {
+		skipIfExcluded(t)
+
+		var expected []uint32
+		for i := 0; i < count; i++ {
+			if scaleFlagged(i) {
+				expected = append(expected, uint32(i+1))
+			}
+		}
+
+		var res []uint32
+		timeOp(t, "SEARCH FLAGGED", len(expected), func() {
+			var err error
+			res, err = mbox.SearchMessages(false, &imap.SearchCriteria{WithFlags: []string{imap.FlaggedFlag}})
+			assert.NilError(t, err)
+		})
+		assert.Check(t, is.DeepEqual(res, expected), "Wrong result of SEARCH FLAGGED")
+
+		seq := new(imap.SeqSet)
+		seq.AddRange(uids[count/2], uids[count-1])
+		timeOp(t, "UID SEARCH UID range UNFLAGGED", count-count/2, func() {
+			var err error
+			res, err = mbox.SearchMessages(true, &imap.SearchCriteria{
+				Uid:          seq,
+				WithoutFlags: []string{imap.FlaggedFlag},
+			})
+			assert.NilError(t, err)
+		})
+		expected = expected[:0]
+		for i := count / 2; i < count; i++ {
+			if !scaleFlagged(i) {
+				expected = append(expected, uids[i])
+			}
+		}
+		assert.Check(t, is.DeepEqual(res, expected), "Wrong result of UID SEARCH UID range UNFLAGGED")
+	})
+	t.Run("STORE 1:*", func(t *testing.T) {
+		skipIfExcluded(t)
+
+		seq, _ := imap.ParseSeqSet("1:*")
+		timeOp(t, "STORE 1:* +FLAGS", count, func() {
+			assert.NilError(t, mbox.UpdateMessagesFlags(false, seq, imap.AddFlags, []string{imap.AnsweredFlag}))
+		})
+
+		res, err := mbox.SearchMessages(false, &imap.SearchCriteria{WithoutFlags: []string{imap.AnsweredFlag}})
+		assert.NilError(t, err)
+		assert.Check(t, is.Len(res, 0), "Flags are not added to all messages")
+	})
+	t.Run("EXPUNGE every other message", func(t *testing.T) {
+		skipIfExcluded(t)
+
+		seq := new(imap.SeqSet)
+		var left []uint32
+		for i := 0; i < count; i++ {
+			if i%2 == 0 {
+				seq.AddNum(uids[i])
+			} else {
+				left = append(left, uids[i])
+			}
+		}
+
+		timeOp(t, "UID STORE every other message +FLAGS (\\Deleted)", count-len(left), func() {
+			assert.NilError(t, mbox.UpdateMessagesFlags(true, seq, imap.AddFlags, []string{imap.DeletedFlag}))
+		})
+		timeOp(t, "EXPUNGE", count-len(left), func() {
+			assert.NilError(t, mbox.Expunge())
+		})
+
+		all, _ := imap.ParseSeqSet("1:*")
+		seqNums, resUids := listScale(t, mbox, false, all)
+		assert.Assert(t, is.Len(seqNums, len(left)), "Wrong amount of messages left after EXPUNGE")
+		for i := range seqNums {
+			assert.Assert(t, is.Equal(seqNums[i], uint32(i+1)), "Sequence numbers are not contiguous after EXPUNGE")
+		}
+		assert.Check(t, is.DeepEqual(resUids, left), "Wrong messages left after EXPUNGE")
+	})
+}
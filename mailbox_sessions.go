@@ -0,0 +1,291 @@
+package backendtests
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/backend"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+// updateSession models IMAP session (e.g. one in IDLE) with selected mailbox.
+// It keeps the list of messages as seen by the client and applies updates
+// that server would route to it (using Username and Mailbox of update).
+type updateSession struct {
+	username string
+	mailbox  string
+
+	// msgs contains messages in order of sequence numbers.
+	msgs []sessionMsg
+}
+
+type sessionMsg struct {
+	// uid is 0 for messages reported by EXISTS, it is filled by check.
+	uid uint32
+	// flags is nil if flags are not known to client (message is reported
+	// by EXISTS and no FETCH was sent for it).
+	flags []string
+}
+
+// newUpdateSession creates session for mailbox with the current state of
+// mailbox. Username should be the name that backend uses in updates.
+func newUpdateSession(t *testing.T, username string, mbox backend.Mailbox) *updateSession {
+	t.Helper()
+
+	return &updateSession{username: username, mailbox: mbox.Name(), msgs: sessionSnapshot(t, mbox)}
+}
+
+// sessionSnapshot returns UIDs and flags (without \Recent) of all messages in
+// mailbox.
+func sessionSnapshot(t *testing.T, mbox backend.Mailbox) []sessionMsg {
+	t.Helper()
+
+	seq, _ := imap.ParseSeqSet("1:*")
+	ch := make(chan *imap.Message, 100)
+	done := make(chan error, 1)
+	go func() {
+		done <- mbox.ListMessages(false, seq, []imap.FetchItem{imap.FetchUid, imap.FetchFlags}, ch)
+	}()
+
+	msgs := []sessionMsg{}
+	for msg := range ch {
+		msgs = append(msgs, sessionMsg{uid: msg.Uid, flags: sessionFlags(msg.Flags)})
+	}
+	assert.NilError(t, <-done)
+	return msgs
+}
+
+// sessionFlags returns sorted copy of flags without \Recent since it is
+// session-specific.
+func sessionFlags(flags []string) []string {
+	res := make([]string, 0, len(flags))
+	for _, flag := range flags {
+		if flag != imap.RecentFlag {
+			res = append(res, flag)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+func (s *updateSession) matches(upd backend.Update) bool {
+	return upd.Username() == s.username && upd.Mailbox() == s.mailbox
+}
+
+// apply changes session state according to the update, as client would do.
+func (s *updateSession) apply(t *testing.T, upd backend.Update) {
+	t.Helper()
+
+	switch upd := upd.(type) {
+	case *backend.ExpungeUpdate:
+		if upd.SeqNum == 0 || upd.SeqNum > uint32(len(s.msgs)) {
+			t.Errorf("ExpungeUpdate SeqNum is out of range: %d (have %d messages)", upd.SeqNum, len(s.msgs))
+			return
+		}
+		s.msgs = append(s.msgs[:upd.SeqNum-1], s.msgs[upd.SeqNum:]...)
+	case *backend.MessageUpdate:
+		if upd.SeqNum == 0 || upd.SeqNum > uint32(len(s.msgs)) {
+			t.Errorf("MessageUpdate SeqNum is out of range: %d (have %d messages)", upd.SeqNum, len(s.msgs))
+			return
+		}
+		msg := &s.msgs[upd.SeqNum-1]
+		if upd.Uid != 0 {
+			if msg.uid != 0 && upd.Uid != msg.uid {
+				t.Errorf("MessageUpdate UID doesn't match SeqNum: %d is %d, not %d", upd.SeqNum, msg.uid, upd.Uid)
+			}
+			msg.uid = upd.Uid
+		}
+		if _, ok := upd.Items[imap.FetchFlags]; ok {
+			msg.flags = sessionFlags(upd.Flags)
+		}
+	case *backend.MailboxUpdate:
+		if _, ok := upd.Items[imap.StatusMessages]; !ok {
+			return
+		}
+		if upd.Messages < uint32(len(s.msgs)) {
+			t.Errorf("EXISTS decreased without EXPUNGE: %d -> %d", len(s.msgs), upd.Messages)
+			return
+		}
+		for uint32(len(s.msgs)) < upd.Messages {
+			s.msgs = append(s.msgs, sessionMsg{})
+		}
+	}
+}
+
+// check compares session state with the actual state of mailbox. UIDs and
+// flags of new messages reported by EXISTS are fetched like client would do.
+func (s *updateSession) check(t *testing.T, mbox backend.Mailbox) {
+	t.Helper()
+
+	actual := sessionSnapshot(t, mbox)
+	assert.Assert(t, is.Len(s.msgs, len(actual)), "Session has wrong amount of messages after applying updates")
+	for i := range s.msgs {
+		msg := &s.msgs[i]
+		if msg.uid == 0 {
+			msg.uid = actual[i].uid
+		}
+		if msg.flags == nil {
+			msg.flags = actual[i].flags
+		}
+		assert.Check(t, is.Equal(msg.uid, actual[i].uid), "Session has wrong message with SeqNum %d after applying updates", i+1)
+		assert.Check(t, is.DeepEqual(msg.flags, actual[i].flags), "Session has wrong flags for message %d after applying updates", i+1)
+	}
+}
+
+// collectUpdates reads updates until no updates are sent for UpdateTimeout/8.
+func collectUpdates(t *testing.T, upds <-chan backend.Update) []backend.Update {
+	t.Helper()
+
+	ctx, cancel := waitContext(t, 5*UpdateTimeout)
+	defer cancel()
+
+	var res []backend.Update
+	for {
+		quiet := time.NewTimer(scaled(UpdateTimeout / 8))
+		select {
+		case upd := <-upds:
+			quiet.Stop()
+			res = append(res, upd)
+		case <-quiet.C:
+			return res
+		case <-ctx.Done():
+			quiet.Stop()
+			t.Fatalf("Backend keeps sending updates (%s), received %d updates: %s", waitError(ctx), len(res), describeUpdates(res))
+		}
+	}
+}
+
+// routeUpdates collects updates and applies them to matching sessions.
+// Updates for username listed in foreign are reported as errors.
+func routeUpdates(t *testing.T, upds <-chan backend.Update, sessions []*updateSession, foreign ...string) []backend.Update {
+	t.Helper()
+
+	collected := collectUpdates(t, upds)
+	for _, upd := range collected {
+		for _, username := range foreign {
+			if upd.Username() == username {
+				t.Errorf("Update for user %s that didn't change anything: %#v", username, upd)
+			}
+		}
+		for _, s := range sessions {
+			if s.matches(upd) {
+				s.apply(t, upd)
+			}
+		}
+	}
+	return collected
+}
+
+func Mailbox_SessionUpdates(t *testing.T, newBack NewBackFunc, closeBack CloseBackFunc) {
+	b := newBack()
+	defer closeBack(b)
+
+	updater, ok := b.(backend.BackendUpdater)
+	if !ok {
+		t.Skip("Backend doesn't supports unilateral updates (need backend.BackendUpdater interface)")
+		t.SkipNow()
+	}
+	upds := updater.Updates()
+
+	u1, u2, mbox1, mbox2 := getIsolatedUsers(t, b)
+	defer assert.NilError(t, u1.Logout())
+	defer assert.NilError(t, u2.Logout())
+	mbox1Other := getNamedMbox(t, u1, "TEST2")
+	collectUpdates(t, upds)
+
+	// Second handle for the same user and mailbox, like another IMAP
+	// connection.
+	u1B, err := b.GetUser(u1.Username())
+	assert.NilError(t, err)
+	defer assert.NilError(t, u1B.Logout())
+	mbox1B, err := u1B.GetMailbox(mbox1.Name())
+	assert.NilError(t, err)
+
+	sessA := newUpdateSession(t, u1.Username(), mbox1)
+	sessB := newUpdateSession(t, u1.Username(), mbox1B)
+	sessOther := newUpdateSession(t, u1.Username(), mbox1Other)
+	sess2 := newUpdateSession(t, u2.Username(), mbox2)
+	sessions := []*updateSession{sessA, sessB, sessOther, sess2}
+
+	t.Run("Routing", func(t *testing.T) {
+		skipIfExcluded(t)
+
+		createMsgs(t, mbox1, 1)
+		for _, upd := range routeUpdates(t, upds, sessions, u2.Username()) {
+			assert.Check(t, is.Equal(upd.Username(), u1.Username()), "Update has wrong username: %#v", upd)
+			assert.Check(t, is.Equal(upd.Mailbox(), mbox1.Name()), "Update has wrong mailbox: %#v", upd)
+		}
+
+		createMsgs(t, mbox1Other, 1)
+		for _, upd := range routeUpdates(t, upds, sessions, u2.Username()) {
+			assert.Check(t, is.Equal(upd.Username(), u1.Username()), "Update has wrong username: %#v", upd)
+			assert.Check(t, is.Equal(upd.Mailbox(), mbox1Other.Name()), "Update has wrong mailbox: %#v", upd)
+		}
+
+		sessA.check(t, mbox1)
+		sessB.check(t, mbox1B)
+		sessOther.check(t, mbox1Other)
+		sess2.check(t, mbox2)
+	})
+	t.Run("Flags from another session", func(t *testing.T) {
+		skipIfExcluded(t)
+
+		seq, _ := imap.ParseSeqSet("2:3")
+		assert.NilError(t, mbox1.UpdateMessagesFlags(false, seq, imap.AddFlags, []string{imap.FlaggedFlag}))
+		collected := routeUpdates(t, upds, sessions, u2.Username())
+		assert.Check(t, len(collected) != 0, "No updates sent for flags change")
+
+		// Session B didn't change anything but should see new flags.
+		sessB.check(t, mbox1B)
+		sessA.check(t, mbox1)
+
+		seq, _ = imap.ParseSeqSet("3")
+		assert.NilError(t, mbox1B.UpdateMessagesFlags(false, seq, imap.RemoveFlags, []string{imap.FlaggedFlag}))
+		routeUpdates(t, upds, sessions, u2.Username())
+		sessA.check(t, mbox1)
+		sessB.check(t, mbox1B)
+	})
+	t.Run("Expunge order", func(t *testing.T) {
+		skipIfExcluded(t)
+
+		createMsgs(t, mbox1, 3)
+		routeUpdates(t, upds, sessions, u2.Username())
+		sessA.check(t, mbox1)
+		sessB.check(t, mbox1B)
+
+		// Non-contiguous set, sequence numbers in updates are valid only if
+		// they are applied in order they are sent.
+		seq, _ := imap.ParseSeqSet("1,3:4,6")
+		assert.NilError(t, mbox1.UpdateMessagesFlags(false, seq, imap.AddFlags, []string{imap.DeletedFlag}))
+		assert.NilError(t, mbox1.Expunge())
+		expunges := 0
+		for _, upd := range routeUpdates(t, upds, sessions, u2.Username()) {
+			if _, ok := upd.(*backend.ExpungeUpdate); ok {
+				expunges++
+			}
+		}
+		assert.Check(t, is.Equal(expunges, 4), "Wrong amount of ExpungeUpdates")
+
+		sessA.check(t, mbox1)
+		sessB.check(t, mbox1B)
+	})
+	t.Run("Other users", func(t *testing.T) {
+		skipIfExcluded(t)
+
+		// Mailbox of username2 has the same name as mailbox of username1.
+		createMsgs(t, mbox2, 2)
+		seq, _ := imap.ParseSeqSet("1")
+		assert.NilError(t, mbox2.UpdateMessagesFlags(false, seq, imap.AddFlags, []string{imap.DeletedFlag}))
+		assert.NilError(t, mbox2.Expunge())
+		for _, upd := range routeUpdates(t, upds, sessions, u1.Username()) {
+			assert.Check(t, is.Equal(upd.Username(), u2.Username()), "Update has wrong username: %#v", upd)
+		}
+
+		sess2.check(t, mbox2)
+		sessA.check(t, mbox1)
+		sessB.check(t, mbox1B)
+	})
+}
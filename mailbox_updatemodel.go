@@ -0,0 +1,169 @@
+package backendtests
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap"
+	move "github.com/emersion/go-imap-move"
+	"github.com/emersion/go-imap/backend"
+	"gotest.tools/assert"
+)
+
+// RandomSeed is used to initialize random number generator for tests that
+// execute random sequences of operations. If it is 0, the current time is
+// used. Used seed is printed in test log so failures can be reproduced.
+var RandomSeed int64
+
+func newTestRand(t *testing.T) *rand.Rand {
+	t.Helper()
+
+	seed := RandomSeed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	t.Logf("Random seed: %d (set RandomSeed to reproduce)", seed)
+	return rand.New(rand.NewSource(seed))
+}
+
+var modelFlags = []string{imap.SeenFlag, imap.FlaggedFlag, imap.AnsweredFlag, "$A", "$B"}
+
+func randomFlags(r *rand.Rand) []string {
+	var flags []string
+	for _, flag := range modelFlags {
+		if r.Intn(3) == 0 {
+			flags = append(flags, flag)
+		}
+	}
+	return flags
+}
+
+// randomSeqSet returns set with up to 3 random messages from snapshot using
+// sequence numbers or UIDs.
+func randomSeqSet(r *rand.Rand, msgs []sessionMsg, uid bool) (*imap.SeqSet, string) {
+	seq := new(imap.SeqSet)
+	for i := 0; i < 1+r.Intn(3); i++ {
+		indx := r.Intn(len(msgs))
+		if uid {
+			seq.AddNum(msgs[indx].uid)
+		} else {
+			seq.AddNum(uint32(indx + 1))
+		}
+	}
+	return seq, seq.String()
+}
+
+// bufferUpdates reads updates from upds in a separate goroutine until stop is
+// closed, so backend is not blocked on sending them while test executes
+// several operations in a row. Read updates are sent to returned channel.
+func bufferUpdates(upds <-chan backend.Update, stop <-chan struct{}) <-chan backend.Update {
+	out := make(chan backend.Update)
+	go func() {
+		var pending []backend.Update
+		for {
+			var (
+				sendCh chan<- backend.Update
+				next   backend.Update
+			)
+			if len(pending) != 0 {
+				sendCh = out
+				next = pending[0]
+			}
+
+			select {
+			case upd, ok := <-upds:
+				if !ok {
+					upds = nil
+					continue
+				}
+				pending = append(pending, upd)
+			case sendCh <- next:
+				pending = pending[1:]
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// Mailbox_UpdateModel executes random sequence of operations and applies
+// all updates sent by backend to the client-side model of mailbox, the model
+// should match the actual mailbox state.
+func Mailbox_UpdateModel(t *testing.T, newBack NewBackFunc, closeBack CloseBackFunc) {
+	b := newBack()
+	defer closeBack(b)
+
+	updater, ok := b.(backend.BackendUpdater)
+	if !ok {
+		t.Skip("Backend doesn't supports unilateral updates (need backend.BackendUpdater interface)")
+		t.SkipNow()
+	}
+	upds := updater.Updates()
+
+	u := getNamedUser(t, b, "username1")
+	defer assert.NilError(t, u.Logout())
+	mbox := getNamedMbox(t, u, "TEST")
+	other := getNamedMbox(t, u, "OTHER")
+	createMsgs(t, mbox, 3)
+	createMsgs(t, other, 3)
+	collectUpdates(t, upds)
+
+	stop := make(chan struct{})
+	defer close(stop)
+	buffered := bufferUpdates(upds, stop)
+
+	sess := newUpdateSession(t, u.Username(), mbox)
+	r := newTestRand(t)
+
+	const (
+		opsCount      = 40
+		checkInterval = 10
+	)
+	for i := 1; i <= opsCount; i++ {
+		msgs := sessionSnapshot(t, mbox)
+
+		op := r.Intn(5)
+		if len(msgs) == 0 {
+			op = 0
+		}
+		if _, ok := mbox.(move.Mailbox); !ok && op == 4 {
+			op = 2
+		}
+
+		switch op {
+		case 0:
+			flags := randomFlags(r)
+			t.Logf("%d: APPEND %v", i, flags)
+			assert.NilError(t, mbox.CreateMessage(flags, time.Now(), strings.NewReader(testMailString)))
+		case 1:
+			uid := r.Intn(2) == 0
+			seq, seqStr := randomSeqSet(r, msgs, uid)
+			flagsOp := []imap.FlagsOp{imap.SetFlags, imap.AddFlags, imap.RemoveFlags}[r.Intn(3)]
+			flags := randomFlags(r)
+			t.Logf("%d: STORE uid=%v %s %v %v", i, uid, seqStr, flagsOp, flags)
+			assert.NilError(t, mbox.UpdateMessagesFlags(uid, seq, flagsOp, flags))
+		case 2:
+			seq, seqStr := randomSeqSet(r, msgs, false)
+			t.Logf("%d: STORE %s +FLAGS (\\Deleted) + EXPUNGE", i, seqStr)
+			assert.NilError(t, mbox.UpdateMessagesFlags(false, seq, imap.AddFlags, []string{imap.DeletedFlag}))
+			assert.NilError(t, mbox.Expunge())
+		case 3:
+			seq := new(imap.SeqSet)
+			seq.AddNum(uint32(1 + r.Intn(3)))
+			t.Logf("%d: COPY %s from %s", i, seq, other.Name())
+			assert.NilError(t, other.CopyMessages(false, seq, mbox.Name()))
+		case 4:
+			seq, seqStr := randomSeqSet(r, msgs, false)
+			t.Logf("%d: MOVE %s to %s", i, seqStr, other.Name())
+			assert.NilError(t, mbox.(move.Mailbox).MoveMessages(false, seq, other.Name()))
+		}
+
+		if i%checkInterval == 0 {
+			routeUpdates(t, buffered, []*updateSession{sess})
+			sess.check(t, mbox)
+		}
+	}
+}
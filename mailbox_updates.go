@@ -25,16 +25,22 @@ func makeMsgSlots(count int) (res []uint32) {
 }
 
 func checkExpungeEvents(t *testing.T, upds <-chan backend.Update, slots *[]uint32, shouldBeLeft uint32) {
-	failTick := time.NewTimer(2 * time.Second)
 	t.Helper()
 	if uint32(len(*slots)) == shouldBeLeft {
 		return
 	}
+
+	ctx, cancel := waitContext(t, UpdateTimeout)
+	defer cancel()
+
+	var received []backend.Update
 	for {
 		select {
-		case <-failTick.C:
-			t.Fatal("ExpungeUpdate's for all messages are not sent in 2 seconds. Remaining slots:", len(*slots))
+		case <-ctx.Done():
+			t.Fatalf("ExpungeUpdate's for all messages are not sent (%s). Remaining slots: %d, received: %s",
+				waitError(ctx), uint32(len(*slots))-shouldBeLeft, describeUpdates(received))
 		case upd := <-upds:
+			received = append(received, upd)
 			switch upd := upd.(type) {
 			case *backend.ExpungeUpdate:
 				if upd.SeqNum > uint32(len(*slots)) {
@@ -86,7 +92,7 @@ func Mailbox_StatusUpdate(t *testing.T, newBack NewBackFunc, closeBack CloseBack
 
 	for i := uint32(1); i <= uint32(5); i++ {
 		createMsgs(t, mbox, 1)
-		upd := readUpdate(t, upds)
+		upd := readUpdate(t, upds, fmt.Sprintf("MailboxUpdate for message %d", i))
 		switch upd := upd.(type) {
 		case *backend.MailboxUpdate:
 			assert.Check(t, is.Equal(upd.Messages, i), "Wrong amount of messages in mailbox reported in update")
@@ -123,7 +129,7 @@ func Mailbox_StatusUpdate_Copy(t *testing.T, newBack NewBackFunc, closeBack Clos
 	seq, _ := imap.ParseSeqSet("2:3")
 	assert.NilError(t, srcMbox.CopyMessages(false, seq, tgtMbox.Name()))
 
-	upd := readUpdate(t, upds)
+	upd := readUpdate(t, upds, "MailboxUpdate for target mailbox")
 	switch upd := upd.(type) {
 	case *backend.MailboxUpdate:
 		assert.Check(t, is.Equal(upd.Mailbox(), tgtMbox.Name()), "Update is for wrong mailbox")
@@ -170,7 +176,7 @@ func Mailbox_StatusUpdate_Move(t *testing.T, newBack NewBackFunc, closeBack Clos
 	msgs := makeMsgSlots(3)
 
 	for i := 0; i < 3; i++ {
-		upd := readUpdate(t, upds)
+		upd := readUpdate(t, upds, fmt.Sprintf("update %d of 3 (1 MailboxUpdate and 2 ExpungeUpdates)", i+1))
 		if upd.Mailbox() == tgtMbox.Name() {
 			mboxUpd, ok := upd.(*backend.MailboxUpdate)
 			if !ok {
@@ -233,7 +239,7 @@ func Mailbox_MessageUpdate(t *testing.T, newBack NewBackFunc, closeBack CloseBac
 			assert.NilError(t, mbox.UpdateMessagesFlags(false, seq, op, opArg))
 
 			for i := 0; i < expectedUpdates; i++ {
-				upd := readUpdate(t, upds)
+				upd := readUpdate(t, upds, fmt.Sprintf("MessageUpdate %d of %d", i+1, expectedUpdates))
 				switch upd := upd.(type) {
 				case *backend.MessageUpdate:
 					flags, ok := expectedNewFlags[upd.SeqNum]
@@ -321,14 +327,18 @@ func Mailbox_MessageUpdate(t *testing.T, newBack NewBackFunc, closeBack CloseBac
 	}
 }
 
-func readUpdate(t *testing.T, upds <-chan backend.Update) backend.Update {
-	timer := time.NewTimer(2 * time.Second)
+// readUpdate waits for the next update, expected describes the update test
+// waits for and is reported on timeout.
+func readUpdate(t *testing.T, upds <-chan backend.Update, expected string) backend.Update {
+	t.Helper()
+
+	ctx, cancel := waitContext(t, UpdateTimeout)
+	defer cancel()
 	select {
 	case upd := <-upds:
-		timer.Stop()
 		return upd
-	case <-timer.C:
-		t.Fatal("Test timeout")
+	case <-ctx.Done():
+		t.Fatalf("Test timeout: %s is not received (%s)", expected, waitError(ctx))
 	}
 	return nil
 }
@@ -0,0 +1,263 @@
+package backendtests
+
+import (
+	"fmt"
+	"runtime/debug"
+	"strings"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/backend"
+)
+
+// Sequences of generated operations (see FuzzBackend and Mailbox_RandomOps)
+// are executed by runOps against backend and reference model of mailboxes,
+// state of backend is compared with model after each operation.
+
+// opFlags are flags set by generated operations.
+var opFlags = []string{imap.SeenFlag, imap.FlaggedFlag, imap.AnsweredFlag, imap.DeletedFlag, "$A", "$B"}
+
+// opsRunner executes operations of a single sequence, see runOps.
+type opsRunner interface {
+	// setup is called before the first operation with the user operations
+	// are executed for.
+	setup(u backend.User) error
+	// exec executes operation with index step.
+	exec(step int) error
+	// check compares state of backend with model.
+	check() error
+}
+
+// runOps creates user username in new backend and executes n operations
+// using runner, state is checked after each operation. Updates sent by
+// backend are discarded.
+//
+// Failures of operations and checks are returned as *stepFailure, panics are
+// returned as errors too.
+func runOps(newBack NewBackFunc, closeBack CloseBackFunc, username string, n int, runner opsRunner) (err error) {
+	step := -1
+	defer func() {
+		if rec := recover(); rec != nil {
+			err = &stepFailure{step: step, err: fmt.Errorf("panic: %v\n%s", rec, debug.Stack())}
+		}
+	}()
+
+	b := newBack()
+	defer closeBack(b)
+	if updater, ok := b.(backend.BackendUpdater); ok {
+		stop := make(chan struct{})
+		defer close(stop)
+		go drainUpdates(updater.Updates(), stop)
+	}
+
+	if err := b.CreateUser(username); err != nil {
+		return err
+	}
+	u, err := b.GetUser(username)
+	if err != nil {
+		return err
+	}
+	defer u.Logout()
+
+	if err := runner.setup(u); err != nil {
+		return err
+	}
+
+	for step = 0; step < n; step++ {
+		if err := runner.exec(step); err != nil {
+			return &stepFailure{step: step, err: err}
+		}
+		if err := runner.check(); err != nil {
+			return &stepFailure{step: step, err: err}
+		}
+	}
+	return nil
+}
+
+type modelMsg struct {
+	// uid is 0 if it is not known yet (e.g. message is just copied), it is
+	// filled from backend state by check.
+	uid uint32
+	// flags are sorted and don't include \Recent.
+	flags  []string
+	recent bool
+	// body is set only if it is needed to check body sections.
+	body []byte
+}
+
+// modelMailbox is the reference model of mailbox as seen by a single
+// session.
+type modelMailbox struct {
+	msgs []modelMsg
+	// uidNext is UIDNEXT returned by backend during the last check, UIDs of
+	// new messages should not be less than it. 0 if it is not known.
+	uidNext uint32
+	// checkRecent enables comparison of \Recent flags and RECENT.
+	checkRecent bool
+}
+
+// seqRange converts first and last of operation into range of sequence
+// numbers of messages in mailbox, ok is false if mailbox is empty.
+func (m *modelMailbox) seqRange(first, last int) (firstSeq, lastSeq uint32, ok bool) {
+	n := len(m.msgs)
+	if n == 0 {
+		return 0, 0, false
+	}
+	firstSeq = 1 + uint32(first%n)
+	lastSeq = 1 + uint32(last%n)
+	if firstSeq > lastSeq {
+		firstSeq, lastSeq = lastSeq, firstSeq
+	}
+	return firstSeq, lastSeq, true
+}
+
+// append adds new message, its UID is learned by check.
+func (m *modelMailbox) append(flags []string, body []byte) {
+	m.msgs = append(m.msgs, modelMsg{flags: sessionFlags(flags), recent: true, body: body})
+}
+
+// updateFlags applies STORE to messages with sequence numbers from first to
+// last.
+func (m *modelMailbox) updateFlags(first, last uint32, op imap.FlagsOp, flags []string) {
+	for i := first - 1; i < last; i++ {
+		m.msgs[i].flags = updateModelFlags(m.msgs[i].flags, op, flags)
+	}
+}
+
+// copyTo appends copies of messages with sequence numbers from first to last
+// to target, which may be the same mailbox.
+func (m *modelMailbox) copyTo(target *modelMailbox, first, last uint32) {
+	copied := append([]modelMsg(nil), m.msgs[first-1:last]...)
+	for _, msg := range copied {
+		target.msgs = append(target.msgs, modelMsg{flags: msg.flags, recent: true, body: msg.body})
+	}
+}
+
+// remove removes messages with sequence numbers from first to last.
+func (m *modelMailbox) remove(first, last uint32) {
+	m.msgs = append(m.msgs[:first-1], m.msgs[last:]...)
+}
+
+// expunge removes messages with \Deleted flag.
+func (m *modelMailbox) expunge() {
+	left := []modelMsg{}
+	for _, msg := range m.msgs {
+		if !hasAttr(msg.flags, imap.DeletedFlag) {
+			left = append(left, msg)
+		}
+	}
+	m.msgs = left
+}
+
+// resetUIDs forgets UIDs of all messages and UIDNEXT, they are learned by
+// the next check. It is used when UIDVALIDITY may change.
+func (m *modelMailbox) resetUIDs() {
+	for i := range m.msgs {
+		m.msgs[i].uid = 0
+	}
+	m.uidNext = 0
+}
+
+// check compares sequence numbers, UIDs, flags and status of mailbox with
+// model. Unknown UIDs are filled from backend, they should be increasing and
+// not less than UIDNEXT seen during the previous check. UIDNEXT is updated
+// from backend.
+func (m *modelMailbox) check(mbox backend.Mailbox) error {
+	name := mbox.Name()
+
+	seq, _ := imap.ParseSeqSet("1:*")
+	ch := make(chan *imap.Message, 100)
+	done := make(chan error, 1)
+	go func() {
+		done <- mbox.ListMessages(false, seq, []imap.FetchItem{imap.FetchUid, imap.FetchFlags}, ch)
+	}()
+	var actual []*imap.Message
+	for msg := range ch {
+		actual = append(actual, msg)
+	}
+	if err := <-done; err != nil {
+		return fmt.Errorf("FETCH %s 1:*: %v", name, err)
+	}
+
+	if len(actual) != len(m.msgs) {
+		return fmt.Errorf("%s contains %d messages, expected %d", name, len(actual), len(m.msgs))
+	}
+	recent := uint32(0)
+	for i, msg := range actual {
+		expected := &m.msgs[i]
+		if msg.SeqNum != uint32(i+1) {
+			return fmt.Errorf("%s: sequence number of message %d is %d", name, i+1, msg.SeqNum)
+		}
+		if i != 0 && msg.Uid <= actual[i-1].Uid {
+			return fmt.Errorf("%s: UIDs are not increasing: %d after %d", name, msg.Uid, actual[i-1].Uid)
+		}
+		if expected.uid == 0 {
+			if msg.Uid < m.uidNext {
+				return fmt.Errorf("%s: UID of new message %d is %d, less than UIDNEXT %d", name, i+1, msg.Uid, m.uidNext)
+			}
+			expected.uid = msg.Uid
+		} else if msg.Uid != expected.uid {
+			return fmt.Errorf("%s: UID of message %d is %d, expected %d", name, i+1, msg.Uid, expected.uid)
+		}
+		flags := sessionFlags(msg.Flags)
+		if strings.Join(flags, " ") != strings.Join(expected.flags, " ") {
+			return fmt.Errorf("%s: flags of message %d are %v, expected %v", name, i+1, flags, expected.flags)
+		}
+		if m.checkRecent && hasAttr(msg.Flags, imap.RecentFlag) != expected.recent {
+			return fmt.Errorf("%s: \\Recent of message %d is %v, expected %v", name, i+1, !expected.recent, expected.recent)
+		}
+		if expected.recent {
+			recent++
+		}
+	}
+
+	items := []imap.StatusItem{imap.StatusMessages, imap.StatusUidNext}
+	if m.checkRecent {
+		items = append(items, imap.StatusRecent)
+	}
+	status, err := mbox.Status(items)
+	if err != nil {
+		return fmt.Errorf("STATUS %s: %v", name, err)
+	}
+	if status.Messages != uint32(len(m.msgs)) {
+		return fmt.Errorf("%s: MESSAGES is %d, expected %d", name, status.Messages, len(m.msgs))
+	}
+	if m.checkRecent && status.Recent != recent {
+		return fmt.Errorf("%s: RECENT is %d, expected %d", name, status.Recent, recent)
+	}
+	if status.UidNext < m.uidNext {
+		return fmt.Errorf("%s: UIDNEXT decreased from %d to %d", name, m.uidNext, status.UidNext)
+	}
+	if len(actual) != 0 && status.UidNext <= actual[len(actual)-1].Uid {
+		return fmt.Errorf("%s: UIDNEXT is %d, not greater than UID %d of the last message", name, status.UidNext, actual[len(actual)-1].Uid)
+	}
+	m.uidNext = status.UidNext
+	return nil
+}
+
+// updateModelFlags returns sorted set of flags after operation.
+func updateModelFlags(current []string, op imap.FlagsOp, flags []string) []string {
+	set := make(map[string]bool)
+	if op != imap.SetFlags {
+		for _, flag := range current {
+			set[flag] = true
+		}
+	}
+	for _, flag := range flags {
+		set[flag] = op != imap.RemoveFlags
+	}
+
+	res := []string{}
+	for flag, ok := range set {
+		if ok {
+			res = append(res, flag)
+		}
+	}
+	return sessionFlags(res)
+}
+
+// copyFlags returns copy of flags. Backends are allowed to modify slices
+// passed to them (backendutil.UpdateFlags does), but operations are reused
+// when failing sequence is minimized.
+func copyFlags(flags []string) []string {
+	return append([]string(nil), flags...)
+}
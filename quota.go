@@ -0,0 +1,16 @@
+package backendtests
+
+import "github.com/emersion/go-imap/backend"
+
+// QuotaUser is extension for backend.User interface which allows to set
+// storage quota for testing and administration purposes.
+type QuotaUser interface {
+	backend.User
+
+	// SetStorageQuota sets maximum total size of messages (in bytes) in
+	// all user mailboxes. nil pointer means no limit.
+	//
+	// Operation that would exceed quota should fail without storing any
+	// messages.
+	SetStorageQuota(val *uint64) error
+}
@@ -0,0 +1,150 @@
+package backendtests
+
+import (
+	"crypto/tls"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/backend"
+)
+
+// RemoteAccount is an existing account on IMAP server used by RemoteBackend.
+type RemoteAccount struct {
+	Username string
+	Password string
+}
+
+// RemoteBackend implements Backend using existing accounts on IMAP server,
+// all operations are executed using go-imap client. It allows to run tests
+// against IMAP servers that are not written in Go.
+//
+// Each user created using CreateUser is mapped to the next unused account,
+// CreateUser fails if all accounts are used already. Tests expect users to
+// have no data, so Reset should be called before passing RemoteBackend to
+// tests. If Reset fails, CreateUser and GetUser return its error, so the
+// test using the backend fails.
+type RemoteBackend struct {
+	Addr string
+	// TLSConfig enables implicit TLS if not nil.
+	TLSConfig *tls.Config
+	Accounts  []RemoteAccount
+
+	lock     sync.Mutex
+	users    map[string]RemoteAccount
+	resetErr error
+	clients  clientSet
+}
+
+func (rb *RemoteBackend) CreateUser(username string) error {
+	rb.lock.Lock()
+	defer rb.lock.Unlock()
+
+	if rb.resetErr != nil {
+		return rb.resetErr
+	}
+	if rb.users == nil {
+		rb.users = make(map[string]RemoteAccount)
+	}
+	if _, ok := rb.users[username]; ok {
+		return ErrUserAlreadyExists
+	}
+	if len(rb.users) >= len(rb.Accounts) {
+		return fmt.Errorf("remote: not enough accounts to create user %s (have %d)", username, len(rb.Accounts))
+	}
+	rb.users[username] = rb.Accounts[len(rb.users)]
+	return nil
+}
+
+func (rb *RemoteBackend) GetUser(username string) (backend.User, error) {
+	rb.lock.Lock()
+	acct, ok := rb.users[username]
+	resetErr := rb.resetErr
+	rb.lock.Unlock()
+	if resetErr != nil {
+		return nil, resetErr
+	}
+	if !ok {
+		return nil, ErrUserDoesNotExist
+	}
+
+	c, err := rb.clients.dial(rb.Addr, rb.TLSConfig, acct.Username, acct.Password)
+	if err != nil {
+		return nil, err
+	}
+	return &wireUser{c: c, username: username, emptyNil: true}, nil
+}
+
+// Reset removes all data from all accounts: mailboxes other than INBOX are
+// deleted, messages from INBOX are expunged and all subscriptions are
+// removed.
+func (rb *RemoteBackend) Reset() error {
+	var err error
+	for _, acct := range rb.Accounts {
+		if err = rb.resetAccount(acct); err != nil {
+			err = fmt.Errorf("remote: reset %s: %v", acct.Username, err)
+			break
+		}
+	}
+
+	rb.lock.Lock()
+	rb.resetErr = err
+	rb.lock.Unlock()
+	return err
+}
+
+func (rb *RemoteBackend) resetAccount(acct RemoteAccount) error {
+	c, err := rb.clients.dial(rb.Addr, rb.TLSConfig, acct.Username, acct.Password)
+	if err != nil {
+		return err
+	}
+	defer c.Logout()
+	u := &wireUser{c: c, username: acct.Username}
+
+	subscribed, err := u.list(true, "*")
+	if err != nil {
+		return err
+	}
+	for _, info := range subscribed {
+		if err := c.Unsubscribe(info.Name); err != nil {
+			return err
+		}
+	}
+
+	mboxes, err := u.list(false, "*")
+	if err != nil {
+		return err
+	}
+	// Children are deleted before parents.
+	sort.Slice(mboxes, func(i, j int) bool {
+		return len(mboxes[i].Name) > len(mboxes[j].Name)
+	})
+	for _, info := range mboxes {
+		if info.Name == imap.InboxName {
+			continue
+		}
+		if err := c.Delete(info.Name); err != nil {
+			return err
+		}
+	}
+
+	status, err := c.Select(imap.InboxName, false)
+	if err != nil {
+		return err
+	}
+	if status.Messages == 0 {
+		return nil
+	}
+	seq, _ := imap.ParseSeqSet("1:*")
+	if err := c.Store(seq, imap.FormatFlagsOp(imap.AddFlags, true), []interface{}{imap.DeletedFlag}, nil); err != nil {
+		return err
+	}
+	return c.Expunge(nil)
+}
+
+// Close terminates all client connections.
+func (rb *RemoteBackend) Close() error {
+	rb.clients.close()
+	return nil
+}
@@ -0,0 +1,115 @@
+package backendtests
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestResult is a result of a single top-level test.
+type TestResult struct {
+	Name     string
+	Status   string // PASS, FAIL or SKIP
+	Duration time.Duration
+}
+
+// TestTiming is a duration of operation executed by scale tests.
+type TestTiming struct {
+	Name      string
+	Operation string
+	// Messages is the amount of messages affected by the operation.
+	Messages int
+	Duration time.Duration
+}
+
+// TestReport collects results of tests executed by RunTests and
+// RunWireTests.
+type TestReport struct {
+	lock    sync.Mutex
+	Results []TestResult
+	Timings []TestTiming
+}
+
+// Report is used to record results of executed tests if it is not nil.
+var Report *TestReport
+
+func (r *TestReport) record(t *testing.T, start time.Time) {
+	res := TestResult{
+		Name:     t.Name(),
+		Status:   "PASS",
+		Duration: time.Since(start),
+	}
+	if t.Failed() {
+		res.Status = "FAIL"
+	} else if t.Skipped() {
+		res.Status = "SKIP"
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.Results = append(r.Results, res)
+}
+
+func (r *TestReport) recordTiming(t *testing.T, operation string, messages int, d time.Duration) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.Timings = append(r.Timings, TestTiming{
+		Name:      t.Name(),
+		Operation: operation,
+		Messages:  messages,
+		Duration:  d,
+	})
+}
+
+// Count returns amount of tests with specified status.
+func (r *TestReport) Count(status string) int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	count := 0
+	for _, res := range r.Results {
+		if res.Status == status {
+			count++
+		}
+	}
+	return count
+}
+
+// WriteTo writes human-readable report with results of all tests, timings
+// of operations and a summary.
+func (r *TestReport) WriteTo(w io.Writer) (int64, error) {
+	r.lock.Lock()
+	results := r.Results
+	timings := r.Timings
+	r.lock.Unlock()
+
+	var written int64
+	for _, res := range results {
+		n, err := fmt.Fprintf(w, "%s\t%s\t(%v)\n", res.Status, res.Name, res.Duration.Round(time.Millisecond))
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+	}
+
+	if len(timings) != 0 {
+		n, err := fmt.Fprintln(w, "\nTimings:")
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+	}
+	for _, tm := range timings {
+		n, err := fmt.Fprintf(w, "%s\t%s\t%d messages\t%v\n", tm.Name, tm.Operation, tm.Messages, tm.Duration.Round(time.Millisecond))
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+	}
+
+	n, err := fmt.Fprintf(w, "\n%d passed, %d failed, %d skipped\n", r.Count("PASS"), r.Count("FAIL"), r.Count("SKIP"))
+	written += int64(n)
+	return written, err
+}
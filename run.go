@@ -5,6 +5,7 @@ import (
 	"runtime"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/emersion/go-imap/backend"
 )
@@ -39,12 +40,22 @@ func getFunctionName(i interface{}) string {
 func RunTests(t *testing.T, newBackend NewBackFunc, closeBackend CloseBackFunc) {
 	addTest := func(f testFunc) {
 		t.Run(getFunctionName(f), func(t *testing.T) {
+			if Report != nil {
+				defer Report.record(t, time.Now())
+			}
 			skipIfExcluded(t)
 			f(t, newBackend, closeBackend)
 		})
 	}
 
 	addTest(TestInit)
+	addTest(Backend_CreateUser_Duplicate)
+	addTest(Backend_GetUser_NonExistent)
+	addTest(Backend_Username_Normalization)
+	addTest(Backend_DeleteUser)
+	addTest(Backend_UserPassword)
+	addTest(Backend_Login)
+	addTest(Backend_CrashConsistency)
 	addTest(User_Username)
 	addTest(User_CreateMailbox)
 	addTest(User_CreateMailbox_Parents)
@@ -53,6 +64,8 @@ func RunTests(t *testing.T, newBackend NewBackFunc, closeBackend CloseBackFunc)
 	addTest(User_RenameMailbox)
 	addTest(User_RenameMailbox_Childrens)
 	addTest(User_RenameMailbox_INBOX)
+	addTest(User_ListPattern)
+	addTest(User_Isolation)
 	addTest(Mailbox_Info)
 	addTest(Mailbox_Children)
 	addTest(Mailbox_Status)
@@ -64,6 +77,8 @@ func RunTests(t *testing.T, newBackend NewBackFunc, closeBackend CloseBackFunc)
 	addTest(Mailbox_ListMessages_BodyPeek)
 	addTest(Mailbox_ListMessages_Meta)
 	addTest(Mailbox_ListMessages_Multi)
+	addTest(Mailbox_ListMessages_Corpus)
+	addTest(Mailbox_Differential)
 	addTest(Mailbox_FetchEncoded)
 	addTest(Mailbox_MatchEncoded)
 	addTest(Mailbox_SearchMessages)
@@ -71,15 +86,33 @@ func RunTests(t *testing.T, newBackend NewBackFunc, closeBackend CloseBackFunc)
 	addTest(Mailbox_MonotonicUid)
 	addTest(Mailbox_Expunge)
 	addTest(Mailbox_CopyMessages)
+	addTest(Mailbox_CopyMessages_Failures)
+	addTest(Mailbox_CopyMessages_SameMailbox)
 
 	addTest(Mailbox_ExpungeUpdate)
 	addTest(Mailbox_StatusUpdate)
 	addTest(Mailbox_StatusUpdate_Copy)
 	addTest(Mailbox_StatusUpdate_Move)
 	addTest(Mailbox_MessageUpdate)
+	addTest(Mailbox_SessionUpdates)
+	addTest(Mailbox_UpdateModel)
+	addTest(Mailbox_RandomOps)
+	addTest(Mailbox_UpdatesBackpressure)
+
+	addTest(Mailbox_Context)
+
+	// Disabled unless ScaleMessages is set.
+	addTest(Mailbox_Scale)
 
 	// MOVE extension
 	addTest(Mailbox_MoveMessages)
+	addTest(Mailbox_MoveMessages_Semantics)
+	addTest(Mailbox_MoveMessages_Updates)
+	addTest(Mailbox_MoveMessages_Failures)
+
+	// LIST-EXTENDED and LIST-STATUS extensions
+	addTest(User_ListExtended)
+	addTest(User_ListStatus)
 
 	// APPEND-LIMIT extension
 	addTest(Backend_AppendLimit)
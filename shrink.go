@@ -0,0 +1,56 @@
+package backendtests
+
+import (
+	"errors"
+	"fmt"
+)
+
+// stepFailure is returned by executors of operation sequences if state of
+// backend diverges from model after operation with index step.
+type stepFailure struct {
+	step int
+	err  error
+}
+
+func (f *stepFailure) Error() string {
+	return fmt.Sprintf("operation %d: %v", f.step+1, f.err)
+}
+
+// truncateSteps removes steps after the failed one.
+func truncateSteps(steps []int, err error) []int {
+	var failure *stepFailure
+	if errors.As(err, &failure) && failure.step >= 0 && failure.step < len(steps) {
+		return steps[:failure.step+1]
+	}
+	return steps
+}
+
+// shrinkSteps removes operations that are not needed to reproduce failure
+// err of sequence with n operations. run executes subsequence of operations
+// with specified indexes and returns error if failure is reproduced.
+// Returned indexes and error are from the last failed run.
+func shrinkSteps(n int, err error, run func(steps []int) error) ([]int, error) {
+	steps := make([]int, n)
+	for i := range steps {
+		steps[i] = i
+	}
+	steps = truncateSteps(steps, err)
+
+	for removed := true; removed; {
+		removed = false
+		for i := len(steps) - 1; i >= 0; i-- {
+			if i >= len(steps) {
+				continue
+			}
+			candidate := make([]int, 0, len(steps)-1)
+			candidate = append(candidate, steps[:i]...)
+			candidate = append(candidate, steps[i+1:]...)
+
+			if candErr := run(candidate); candErr != nil {
+				steps, err = truncateSteps(candidate, candErr), candErr
+				removed = true
+			}
+		}
+	}
+	return steps, err
+}
@@ -0,0 +1,84 @@
+package backendtests
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap/backend"
+)
+
+// UpdateTimeout is the time tests wait for an expected unilateral update
+// before failing.
+var UpdateTimeout = 2 * time.Second
+
+// CancelTimeout is the time backend has to return from operation after its
+// context is cancelled. It is scaled by TimeoutScale.
+var CancelTimeout = time.Second
+
+// TimeoutScale is multiplier applied to UpdateTimeout, CancelTimeout and
+// WireTimeout. Values greater than 1 should be used for slow backends (e.g.
+// ones using SQL server over network) or loaded machines, values less than 1
+// make tests for in-memory backends faster.
+var TimeoutScale float64 = 1
+
+// scaled returns timeout d multiplied by TimeoutScale.
+func scaled(d time.Duration) time.Duration {
+	if TimeoutScale <= 0 {
+		return d
+	}
+	return time.Duration(float64(d) * TimeoutScale)
+}
+
+// deadlineGrace is the time reserved before the test binary deadline (see
+// go test -timeout) to report the failure instead of panicking.
+const deadlineGrace = time.Second
+
+// waitContext returns context used for waiting for something for at most
+// scaled timeout d. Context deadline is never later than the deadline of
+// test binary.
+func waitContext(t *testing.T, d time.Duration) (context.Context, context.CancelFunc) {
+	deadline := time.Now().Add(scaled(d))
+	if testDeadline, ok := t.Deadline(); ok && testDeadline.Add(-deadlineGrace).Before(deadline) {
+		deadline = testDeadline.Add(-deadlineGrace)
+	}
+	return context.WithDeadline(context.Background(), deadline)
+}
+
+// waitError describes why wait using ctx is terminated.
+func waitError(ctx context.Context) string {
+	if ctx.Err() == context.DeadlineExceeded {
+		deadline, _ := ctx.Deadline()
+		return fmt.Sprintf("deadline %v exceeded", deadline.Format("15:04:05.000"))
+	}
+	return ctx.Err().Error()
+}
+
+// describeUpdates formats updates for failure messages.
+func describeUpdates(upds []backend.Update) string {
+	if len(upds) == 0 {
+		return "none"
+	}
+	descs := make([]string, 0, len(upds))
+	for _, upd := range upds {
+		descs = append(descs, describeUpdate(upd))
+	}
+	return strings.Join(descs, ", ")
+}
+
+func describeUpdate(upd backend.Update) string {
+	switch upd := upd.(type) {
+	case *backend.ExpungeUpdate:
+		return fmt.Sprintf("ExpungeUpdate(%s/%s, SeqNum=%d)", upd.Username(), upd.Mailbox(), upd.SeqNum)
+	case *backend.MessageUpdate:
+		return fmt.Sprintf("MessageUpdate(%s/%s, SeqNum=%d)", upd.Username(), upd.Mailbox(), upd.SeqNum)
+	case *backend.MailboxUpdate:
+		return fmt.Sprintf("MailboxUpdate(%s/%s, Messages=%d)", upd.Username(), upd.Mailbox(), upd.Messages)
+	case nil:
+		return "nil"
+	default:
+		return fmt.Sprintf("%T(%s/%s)", upd, upd.Username(), upd.Mailbox())
+	}
+}
@@ -0,0 +1,363 @@
+package backendtests
+
+import (
+	"bufio"
+	"bytes"
+	"embed"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"gotest.tools/assert"
+)
+
+// Transcript is a scripted IMAP session that is executed against the backend
+// through in-process go-imap server.
+//
+// Transcript file consists of lines with the following prefixes:
+//
+//	# comment, ignored (as well as empty lines)
+//	C: line sent by client
+//	L: line of literal for the previous C: line
+//	S: pattern for line expected from server
+//	U: same as S:, but consecutive U: lines can match in any order
+//
+// Session starts in authenticated state, user is named "username1".
+// Consecutive C: lines are sent as is (with CRLF appended), after that one
+// server line is read for each of following S: lines and matched against it.
+// All server lines must be listed, including untagged responses and lines of
+// literals.
+//
+// C: line ending with {{literal}} starts a literal, {{literal}} is replaced
+// with {N+} where N is the length of following L: lines (each line is
+// terminated with CRLF). Command is completed after the last L: line.
+//
+// U: lines are useful for responses that go-imap server sends in random
+// order, e.g. untagged responses for SELECT.
+//
+// S: line must match the whole server line. The following placeholders are
+// supported:
+//
+//	{{num}}       - any number
+//	{{date}}      - date-time value (without quotes)
+//	{{any}}       - any text (possibly empty)
+//	{{num:NAME}}  - any number, it is remembered as NAME
+//	{{NAME}}      - previously remembered value (also allowed in C: lines,
+//	                where NAME must be remembered already)
+type Transcript struct {
+	Name  string
+	steps []transcriptStep
+}
+
+type transcriptStep struct {
+	line int
+
+	// For client steps.
+	client  bool
+	text    string
+	literal []string
+
+	// For server steps.
+	pattern   string
+	unordered bool
+}
+
+// ParseTranscript reads transcript in the format described in Transcript
+// documentation. Name is used only in error messages.
+func ParseTranscript(name string, r io.Reader) (*Transcript, error) {
+	tr := &Transcript{Name: name}
+
+	scanner := bufio.NewScanner(r)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if len(line) < 2 || line[1] != ':' {
+			return nil, fmt.Errorf("%s:%d: line should start with C:, L:, S: or U:", name, lineNum)
+		}
+		text := strings.TrimPrefix(line[2:], " ")
+
+		switch line[0] {
+		case 'C':
+			tr.steps = append(tr.steps, transcriptStep{line: lineNum, client: true, text: text})
+		case 'L':
+			if len(tr.steps) == 0 {
+				return nil, fmt.Errorf("%s:%d: L: line without preceding C: line", name, lineNum)
+			}
+			last := &tr.steps[len(tr.steps)-1]
+			if !last.client || !strings.HasSuffix(last.text, "{{literal}}") {
+				return nil, fmt.Errorf("%s:%d: L: line should follow C: line ending with {{literal}}", name, lineNum)
+			}
+			last.literal = append(last.literal, text)
+		case 'S', 'U':
+			if _, err := regexp.Compile(transcriptPattern(text, nil)); err != nil {
+				return nil, fmt.Errorf("%s:%d: %v", name, lineNum, err)
+			}
+			tr.steps = append(tr.steps, transcriptStep{line: lineNum, pattern: text, unordered: line[0] == 'U'})
+		default:
+			return nil, fmt.Errorf("%s:%d: line should start with C:, L:, S: or U:", name, lineNum)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+
+	for _, step := range tr.steps {
+		if step.client && strings.HasSuffix(step.text, "{{literal}}") && step.literal == nil {
+			return nil, fmt.Errorf("%s:%d: missing L: lines for literal", name, step.line)
+		}
+	}
+
+	return tr, nil
+}
+
+var transcriptPlaceholder = regexp.MustCompile(`{{([a-zA-Z0-9_:]+)}}`)
+
+// transcriptPattern converts S: line into regular expression. Captured
+// values are named using (?P<NAME>) syntax.
+func transcriptPattern(text string, vars map[string]string) string {
+	var res strings.Builder
+	res.WriteString("^")
+	last := 0
+	for _, loc := range transcriptPlaceholder.FindAllStringSubmatchIndex(text, -1) {
+		res.WriteString(regexp.QuoteMeta(text[last:loc[0]]))
+		last = loc[1]
+
+		name := text[loc[2]:loc[3]]
+		switch {
+		case name == "num":
+			res.WriteString(`\d+`)
+		case name == "date":
+			res.WriteString(`[ \d]\d-[A-Za-z]{3}-\d{4} \d{2}:\d{2}:\d{2} [+-]\d{4}`)
+		case name == "any":
+			res.WriteString(`.*`)
+		case strings.HasPrefix(name, "num:"):
+			res.WriteString(`(?P<` + strings.TrimPrefix(name, "num:") + `>\d+)`)
+		default:
+			if val, ok := vars[name]; ok {
+				res.WriteString(regexp.QuoteMeta(val))
+			} else {
+				res.WriteString(regexp.QuoteMeta(text[loc[0]:loc[1]]))
+			}
+		}
+	}
+	res.WriteString(regexp.QuoteMeta(text[last:]))
+	res.WriteString("$")
+	return res.String()
+}
+
+// expandTranscriptVars replaces {{NAME}} in C: line with remembered values.
+// {{literal}} is left as is. Error is returned if value with such name was not
+// remembered.
+func expandTranscriptVars(text string, vars map[string]string) (string, error) {
+	var err error
+	expanded := transcriptPlaceholder.ReplaceAllStringFunc(text, func(placeholder string) string {
+		name := placeholder[2 : len(placeholder)-2]
+		if name == "literal" {
+			return placeholder
+		}
+		if val, ok := vars[name]; ok {
+			return val
+		}
+		if err == nil {
+			err = fmt.Errorf("unknown placeholder %s in line %q", placeholder, text)
+		}
+		return placeholder
+	})
+	return expanded, err
+}
+
+// transcriptConn is a raw connection to the in-process server.
+type transcriptConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func (c *transcriptConn) readLine() (string, error) {
+	if err := c.conn.SetReadDeadline(time.Now().Add(scaled(WireTimeout))); err != nil {
+		return "", err
+	}
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func (c *transcriptConn) write(data string) error {
+	if err := c.conn.SetWriteDeadline(time.Now().Add(scaled(WireTimeout))); err != nil {
+		return err
+	}
+	_, err := io.WriteString(c.conn, data)
+	return err
+}
+
+// login reads server greeting and logs in as specified user.
+func (c *transcriptConn) login(username string) error {
+	greeting, err := c.readLine()
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(greeting, "* OK") {
+		return fmt.Errorf("unexpected greeting: %s", greeting)
+	}
+
+	if err := c.write("login LOGIN " + strconv.Quote(username) + " " + strconv.Quote(wirePassword) + "\r\n"); err != nil {
+		return err
+	}
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(line, "login OK") {
+			return nil
+		}
+		if strings.HasPrefix(line, "login ") {
+			return fmt.Errorf("login failed: %s", line)
+		}
+	}
+}
+
+// RunTranscript executes transcript against backend. User "username1" is
+// created before the session is started.
+func RunTranscript(t *testing.T, b Backend, tr *Transcript) {
+	t.Helper()
+
+	assert.NilError(t, b.CreateUser("username1"))
+
+	wb := newWireBackend(b, false)
+	defer wb.Close()
+	assert.NilError(t, wb.err)
+
+	conn, err := net.Dial("tcp", wb.listener.Addr().String())
+	assert.NilError(t, err)
+	defer conn.Close()
+	c := &transcriptConn{conn: conn, r: bufio.NewReader(conn)}
+	assert.NilError(t, c.login("username1"))
+
+	vars := make(map[string]string)
+	for i, step := range tr.steps {
+		if step.client {
+			text, err := expandTranscriptVars(step.text, vars)
+			assert.NilError(t, err, "%s:%d", tr.Name, step.line)
+			if step.literal == nil {
+				assert.NilError(t, c.write(text+"\r\n"), "%s:%d", tr.Name, step.line)
+				continue
+			}
+
+			var literal bytes.Buffer
+			for _, line := range step.literal {
+				literal.WriteString(line + "\r\n")
+			}
+			text = strings.TrimSuffix(text, "{{literal}}") + "{" + strconv.Itoa(literal.Len()) + "+}\r\n"
+			assert.NilError(t, c.write(text+literal.String()+"\r\n"), "%s:%d", tr.Name, step.line)
+			continue
+		}
+
+		if !step.unordered {
+			line, err := c.readLine()
+			assert.NilError(t, err, "%s:%d: failed to read server response", tr.Name, step.line)
+			if !matchTranscriptLine(step.pattern, line, vars) {
+				t.Fatalf("%s:%d: server response mismatch\nexpected: %s\ngot:      %s", tr.Name, step.line, step.pattern, line)
+			}
+			continue
+		}
+
+		// Collect all consecutive U: lines and match them at the first
+		// one.
+		if i > 0 && tr.steps[i-1].unordered {
+			continue
+		}
+		var group []transcriptStep
+		for _, s := range tr.steps[i:] {
+			if !s.unordered {
+				break
+			}
+			group = append(group, s)
+		}
+		for range group {
+			line, err := c.readLine()
+			assert.NilError(t, err, "%s:%d: failed to read server response", tr.Name, step.line)
+
+			matched := false
+			for j, s := range group {
+				if matchTranscriptLine(s.pattern, line, vars) {
+					group = append(group[:j], group[j+1:]...)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				t.Fatalf("%s:%d: unexpected server response: %s", tr.Name, step.line, line)
+			}
+		}
+	}
+}
+
+// matchTranscriptLine checks whether server line matches S: line pattern and
+// remembers captured values.
+func matchTranscriptLine(pattern, line string, vars map[string]string) bool {
+	re := regexp.MustCompile(transcriptPattern(pattern, vars))
+	match := re.FindStringSubmatch(line)
+	if match == nil {
+		return false
+	}
+	for i, name := range re.SubexpNames() {
+		if name != "" {
+			vars[name] = match[i]
+		}
+	}
+	return true
+}
+
+// RunTranscripts executes all transcripts (*.imap files) from fsys, each
+// transcript is executed against a new backend instance as a separate
+// subtest.
+//
+// Use os.DirFS to run transcripts from a directory.
+func RunTranscripts(t *testing.T, newBack NewBackFunc, closeBack CloseBackFunc, fsys fs.FS) {
+	names, err := fs.Glob(fsys, "*.imap")
+	assert.NilError(t, err)
+	sort.Strings(names)
+
+	for _, name := range names {
+		name := name
+		t.Run(strings.TrimSuffix(path.Base(name), ".imap"), func(t *testing.T) {
+			skipIfExcluded(t)
+
+			f, err := fsys.Open(name)
+			assert.NilError(t, err)
+			defer f.Close()
+			tr, err := ParseTranscript(name, f)
+			assert.NilError(t, err)
+
+			b := newBack()
+			defer closeBack(b)
+			RunTranscript(t, b, tr)
+		})
+	}
+}
+
+//go:embed transcripts/*.imap
+var bundledTranscripts embed.FS
+
+// Wire_Transcripts runs transcripts bundled with the test suite (see
+// transcripts directory).
+func Wire_Transcripts(t *testing.T, newBack NewBackFunc, closeBack CloseBackFunc) {
+	fsys, err := fs.Sub(bundledTranscripts, "transcripts")
+	assert.NilError(t, err)
+	RunTranscripts(t, newBack, closeBack, fsys)
+}
@@ -0,0 +1,17 @@
+package backendtests
+
+import "github.com/emersion/go-imap/backend"
+
+// UpdateDropBackend is extension for backend.BackendUpdater interface which
+// documents that backend drops unilateral updates instead of blocking
+// operations if nobody reads the Updates channel.
+//
+// Backends that don't implement this interface are expected to never drop
+// updates and to never block operations because of unread updates.
+type UpdateDropBackend interface {
+	backend.BackendUpdater
+
+	// UpdatesBufferSize returns the amount of unread updates that are
+	// buffered before backend starts dropping them.
+	UpdatesBufferSize() int
+}
@@ -0,0 +1,214 @@
+package backendtests
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/backend"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+// getIsolatedUsers creates two users with identically named mailbox TEST.
+// First user gets 3 messages in it, second one gets only 1.
+func getIsolatedUsers(t *testing.T, b Backend) (u1, u2 backend.User, mbox1, mbox2 backend.Mailbox) {
+	t.Helper()
+
+	u1 = getNamedUser(t, b, "username1")
+	u2 = getNamedUser(t, b, "username2")
+
+	mbox1 = getNamedMbox(t, u1, "TEST")
+	mbox2 = getNamedMbox(t, u2, "TEST")
+
+	createMsgs(t, mbox1, 3)
+	createMsgs(t, mbox2, 1)
+	return
+}
+
+func User_Isolation(t *testing.T, newBack NewBackFunc, closeBack CloseBackFunc) {
+	t.Run("ListMailboxes", func(t *testing.T) {
+		skipIfExcluded(t)
+
+		b := newBack()
+		defer closeBack(b)
+		u1, u2, _, _ := getIsolatedUsers(t, b)
+		defer assert.NilError(t, u1.Logout())
+		defer assert.NilError(t, u2.Logout())
+
+		assert.NilError(t, u1.CreateMailbox("ONLYFIRST"))
+		assert.NilError(t, u2.CreateMailbox("ONLYSECOND"))
+
+		names1 := mailboxNames(t, u1, false)
+		names2 := mailboxNames(t, u2, false)
+		assert.Check(t, is.Contains(names1, "ONLYFIRST"))
+		assert.Check(t, !is.Contains(names1, "ONLYSECOND")().Success(), "Mailbox of another user is listed")
+		assert.Check(t, is.Contains(names2, "ONLYSECOND"))
+		assert.Check(t, !is.Contains(names2, "ONLYFIRST")().Success(), "Mailbox of another user is listed")
+
+		_, err := u1.GetMailbox("ONLYSECOND")
+		assert.Error(t, err, backend.ErrNoSuchMailbox.Error(), "Mailbox of another user is accessible")
+		_, err = u2.GetMailbox("ONLYFIRST")
+		assert.Error(t, err, backend.ErrNoSuchMailbox.Error(), "Mailbox of another user is accessible")
+
+		assert.NilError(t, u1.DeleteMailbox("TEST"))
+		_, err = u2.GetMailbox("TEST")
+		assert.NilError(t, err, "Deletion of mailbox affected another user")
+	})
+	t.Run("Status", func(t *testing.T) {
+		skipIfExcluded(t)
+
+		b := newBack()
+		defer closeBack(b)
+		u1, u2, mbox1, mbox2 := getIsolatedUsers(t, b)
+		defer assert.NilError(t, u1.Logout())
+		defer assert.NilError(t, u2.Logout())
+
+		seq, _ := imap.ParseSeqSet("1")
+		assert.NilError(t, mbox1.UpdateMessagesFlags(false, seq, imap.AddFlags, []string{imap.SeenFlag}))
+
+		items := []imap.StatusItem{imap.StatusMessages, imap.StatusRecent, imap.StatusUnseen}
+
+		status1, err := mbox1.Status(items)
+		assert.NilError(t, err)
+		assert.Check(t, is.Equal(status1.Messages, uint32(3)), "Wrong MESSAGES value for first user")
+		assert.Check(t, is.Equal(status1.Unseen, uint32(2)), "Wrong UNSEEN value for first user")
+
+		status2, err := mbox2.Status(items)
+		assert.NilError(t, err)
+		assert.Check(t, is.Equal(status2.Messages, uint32(1)), "Wrong MESSAGES value for second user")
+		assert.Check(t, is.Equal(status2.Recent, uint32(1)), "Wrong RECENT value for second user")
+		assert.Check(t, is.Equal(status2.Unseen, uint32(1)), "Flags change affected another user")
+	})
+	t.Run("SearchMessages", func(t *testing.T) {
+		skipIfExcluded(t)
+
+		b := newBack()
+		defer closeBack(b)
+		u1, u2, mbox1, mbox2 := getIsolatedUsers(t, b)
+		defer assert.NilError(t, u1.Logout())
+		defer assert.NilError(t, u2.Logout())
+
+		seq, _ := imap.ParseSeqSet("1")
+		assert.NilError(t, mbox1.UpdateMessagesFlags(false, seq, imap.AddFlags, []string{imap.SeenFlag}))
+
+		res, err := mbox1.SearchMessages(false, &imap.SearchCriteria{})
+		assert.NilError(t, err)
+		assert.Check(t, is.DeepEqual(res, []uint32{1, 2, 3}))
+
+		res, err = mbox2.SearchMessages(false, &imap.SearchCriteria{})
+		assert.NilError(t, err)
+		assert.Check(t, is.DeepEqual(res, []uint32{1}), "Messages of another user are matched")
+
+		res, err = mbox2.SearchMessages(false, &imap.SearchCriteria{WithFlags: []string{imap.SeenFlag}})
+		assert.NilError(t, err)
+		assert.Check(t, is.Len(res, 0), "Flags of another user's message are matched")
+	})
+	t.Run("CopyMessages", func(t *testing.T) {
+		skipIfExcluded(t)
+
+		b := newBack()
+		defer closeBack(b)
+		u1, u2, mbox1, _ := getIsolatedUsers(t, b)
+		defer assert.NilError(t, u1.Logout())
+		defer assert.NilError(t, u2.Logout())
+
+		tgtMbox := getNamedMbox(t, u2, "ONLYSECOND")
+
+		seq, _ := imap.ParseSeqSet("1:*")
+		assert.Error(t, mbox1.CopyMessages(false, seq, "ONLYSECOND"), backend.ErrNoSuchMailbox.Error(),
+			"Messages are copied into mailbox of another user")
+
+		status, err := tgtMbox.Status([]imap.StatusItem{imap.StatusMessages})
+		assert.NilError(t, err)
+		assert.Check(t, is.Equal(status.Messages, uint32(0)), "Messages appeared in mailbox of another user")
+
+		// Copy to identically named mailbox should go to the same user.
+		assert.NilError(t, mbox1.CopyMessages(false, seq, "TEST"))
+		mbox2, err := u2.GetMailbox("TEST")
+		assert.NilError(t, err)
+		status, err = mbox2.Status([]imap.StatusItem{imap.StatusMessages})
+		assert.NilError(t, err)
+		assert.Check(t, is.Equal(status.Messages, uint32(1)), "Messages are copied into mailbox of another user")
+	})
+	t.Run("Subscriptions", func(t *testing.T) {
+		skipIfExcluded(t)
+
+		b := newBack()
+		defer closeBack(b)
+		u1, u2, mbox1, _ := getIsolatedUsers(t, b)
+		defer assert.NilError(t, u1.Logout())
+		defer assert.NilError(t, u2.Logout())
+
+		assert.NilError(t, mbox1.SetSubscribed(true))
+		assert.Check(t, is.Contains(mailboxNames(t, u1, true), "TEST"))
+		assert.Check(t, !is.Contains(mailboxNames(t, u2, true), "TEST")().Success(), "Subscription is visible to another user")
+	})
+	t.Run("AppendLimit", func(t *testing.T) {
+		skipIfExcluded(t)
+
+		b := newBack()
+		defer closeBack(b)
+		u1, u2, mbox1, mbox2 := getIsolatedUsers(t, b)
+		defer assert.NilError(t, u1.Logout())
+		defer assert.NilError(t, u2.Logout())
+
+		uAL1, ok1 := u1.(AppendLimitUser)
+		uAL2, ok2 := u2.(AppendLimitUser)
+		mAL1, ok3 := mbox1.(AppendLimitMbox)
+		mAL2, ok4 := mbox2.(AppendLimitMbox)
+		if !ok1 || !ok2 || !ok3 || !ok4 {
+			t.Skip("APPENDLIMIT extension is not implemented (need AppendLimitUser and AppendLimitMbox interfaces)")
+			t.SkipNow()
+		}
+
+		lim := uint32(500)
+		assert.NilError(t, uAL1.SetMessageLimit(&lim))
+		assert.Check(t, is.Nil(uAL2.CreateMessageLimit()), "User limit is applied to another user")
+		assert.NilError(t, mbox2.CreateMessage([]string{}, time.Now(), strings.NewReader(headerStub+strings.Repeat("A", 700))))
+		assert.NilError(t, uAL1.SetMessageLimit(nil))
+
+		assert.NilError(t, mAL1.SetMessageLimit(&lim))
+		assert.Check(t, is.Nil(mAL2.CreateMessageLimit()), "Mailbox limit is applied to mailbox of another user")
+		assert.NilError(t, mbox2.CreateMessage([]string{}, time.Now(), strings.NewReader(headerStub+strings.Repeat("A", 700))))
+	})
+	t.Run("Updates", func(t *testing.T) {
+		skipIfExcluded(t)
+
+		b := newBack()
+		defer closeBack(b)
+
+		updater, ok := b.(backend.BackendUpdater)
+		if !ok {
+			t.Skip("Backend doesn't supports unilateral updates (need backend.BackendUpdater interface)")
+			t.SkipNow()
+		}
+		upds := updater.Updates()
+
+		u1, u2, mbox1, mbox2 := getIsolatedUsers(t, b)
+		defer assert.NilError(t, u1.Logout())
+		defer assert.NilError(t, u2.Logout())
+		collectUpdates(t, upds)
+
+		checkUpdate := func(username string, messages uint32) {
+			t.Helper()
+			upd := readUpdate(t, upds, fmt.Sprintf("MailboxUpdate for %s", username))
+			assert.Check(t, is.Equal(upd.Username(), username), "Update is for wrong user")
+			assert.Check(t, is.Equal(upd.Mailbox(), "TEST"), "Update is for wrong mailbox")
+			switch upd := upd.(type) {
+			case *backend.MailboxUpdate:
+				assert.Check(t, is.Equal(upd.Messages, messages), "Wrong amount of messages in mailbox reported in update")
+			default:
+				t.Errorf("Non-mailbox update sent by backend: %#v\n", upd)
+			}
+		}
+
+		createMsgs(t, mbox1, 1)
+		checkUpdate("username1", 4)
+
+		createMsgs(t, mbox2, 1)
+		checkUpdate("username2", 2)
+	})
+}
@@ -0,0 +1,213 @@
+package backendtests
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/backend"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+func findListInfo(infos []ListInfo, name string) *ListInfo {
+	for i := range infos {
+		if infos[i].Name == name {
+			return &infos[i]
+		}
+	}
+	return nil
+}
+
+func listInfoNames(infos []ListInfo) []string {
+	names := make([]string, 0, len(infos))
+	for _, info := range infos {
+		names = append(names, info.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// setupListTree creates following mailboxes:
+//
+//	Foo (not subscribed)
+//	Foo.Bar (subscribed)
+//	Baz (subscribed)
+//	Qux, Qux.Quux (not subscribed)
+//	Gone (subscribed, deleted)
+func setupListTree(t *testing.T, u backend.User) {
+	t.Helper()
+	assert.NilError(t, getNamedMbox(t, u, "Foo.Bar").SetSubscribed(true))
+	assert.NilError(t, getNamedMbox(t, u, "Baz").SetSubscribed(true))
+	assert.NilError(t, u.CreateMailbox("Qux.Quux"))
+	assert.NilError(t, getNamedMbox(t, u, "Gone").SetSubscribed(true))
+	assert.NilError(t, u.DeleteMailbox("Gone"))
+}
+
+func User_ListExtended(t *testing.T, newBack NewBackFunc, closeBack CloseBackFunc) {
+	b := newBack()
+	defer closeBack(b)
+	u := getUser(t, b)
+	defer assert.NilError(t, u.Logout())
+
+	leUser, ok := u.(ListExtendedUser)
+	if !ok {
+		t.Skip("LIST-EXTENDED extension is not implemented (need ListExtendedUser interface)")
+		t.SkipNow()
+	}
+
+	setupListTree(t, u)
+
+	t.Run("No options", func(t *testing.T) {
+		skipIfExcluded(t)
+
+		infos, err := leUser.ListMailboxesExtended("", []string{"*"}, &ListOptions{})
+		assert.NilError(t, err)
+		assert.DeepEqual(t, listInfoNames(infos), mailboxNames(t, u, false))
+
+		for _, info := range infos {
+			assert.Check(t, !hasAttr(info.Attributes, NonExistentAttr), "\\NonExistent returned without SUBSCRIBED for %s", info.Name)
+		}
+	})
+	t.Run("SUBSCRIBED", func(t *testing.T) {
+		skipIfExcluded(t)
+
+		infos, err := leUser.ListMailboxesExtended("", []string{"*"}, &ListOptions{Subscribed: true})
+		assert.NilError(t, err)
+		assert.DeepEqual(t, listInfoNames(infos), []string{"Baz", "Foo.Bar", "Gone"})
+
+		for _, info := range infos {
+			assert.Check(t, hasAttr(info.Attributes, SubscribedAttr), "Missing \\Subscribed on %s", info.Name)
+			if info.Name == "Gone" {
+				assert.Check(t, hasAttr(info.Attributes, NonExistentAttr), "Missing \\NonExistent on deleted mailbox")
+			} else {
+				assert.Check(t, !hasAttr(info.Attributes, NonExistentAttr), "\\NonExistent on existing mailbox %s", info.Name)
+			}
+		}
+	})
+	t.Run("RETURN SUBSCRIBED", func(t *testing.T) {
+		skipIfExcluded(t)
+
+		infos, err := leUser.ListMailboxesExtended("", []string{"*"}, &ListOptions{ReturnSubscribed: true})
+		assert.NilError(t, err)
+		assert.DeepEqual(t, listInfoNames(infos), mailboxNames(t, u, false))
+
+		for _, info := range infos {
+			subscribed := info.Name == "Baz" || info.Name == "Foo.Bar"
+			assert.Check(t, is.Equal(hasAttr(info.Attributes, SubscribedAttr), subscribed), "Wrong \\Subscribed attribute on %s", info.Name)
+		}
+	})
+	t.Run("SUBSCRIBED RECURSIVEMATCH", func(t *testing.T) {
+		skipIfExcluded(t)
+
+		// See RFC 5258, Section 5, example 9.
+		infos, err := leUser.ListMailboxesExtended("", []string{"%"}, &ListOptions{Subscribed: true, RecursiveMatch: true})
+		assert.NilError(t, err)
+		assert.DeepEqual(t, listInfoNames(infos), []string{"Baz", "Foo", "Gone"})
+
+		foo := findListInfo(infos, "Foo")
+		assert.Check(t, !hasAttr(foo.Attributes, SubscribedAttr), "\\Subscribed on not subscribed parent")
+		assert.Check(t, is.Contains(foo.ChildInfo, "SUBSCRIBED"), "Missing CHILDINFO for parent of subscribed mailbox")
+
+		baz := findListInfo(infos, "Baz")
+		assert.Check(t, hasAttr(baz.Attributes, SubscribedAttr), "Missing \\Subscribed on Baz")
+		assert.Check(t, is.Len(baz.ChildInfo, 0), "CHILDINFO for mailbox without subscribed children")
+	})
+	t.Run("RECURSIVEMATCH alone", func(t *testing.T) {
+		skipIfExcluded(t)
+
+		_, err := leUser.ListMailboxesExtended("", []string{"*"}, &ListOptions{RecursiveMatch: true})
+		assert.Check(t, err != nil, "RECURSIVEMATCH without other selection options is accepted")
+	})
+	t.Run("REMOTE", func(t *testing.T) {
+		skipIfExcluded(t)
+
+		infos, err := leUser.ListMailboxesExtended("", []string{"*"}, &ListOptions{Remote: true})
+		assert.NilError(t, err)
+
+		local := make(map[string]bool)
+		for _, name := range mailboxNames(t, u, false) {
+			local[name] = true
+
+			info := findListInfo(infos, name)
+			if !assert.Check(t, info != nil, "Local mailbox %s is not returned with REMOTE", name) {
+				continue
+			}
+			assert.Check(t, !hasAttr(info.Attributes, RemoteAttr), "\\Remote on local mailbox %s", name)
+		}
+
+		// Backend may have no remote mailboxes, but if it has - they should be marked.
+		for _, info := range infos {
+			if !local[info.Name] {
+				assert.Check(t, hasAttr(info.Attributes, RemoteAttr), "Missing \\Remote on non-local mailbox %s", info.Name)
+			}
+		}
+	})
+}
+
+func User_ListStatus(t *testing.T, newBack NewBackFunc, closeBack CloseBackFunc) {
+	b := newBack()
+	defer closeBack(b)
+	u := getUser(t, b)
+	defer assert.NilError(t, u.Logout())
+
+	lsUser, ok := u.(ListStatusUser)
+	if !ok {
+		t.Skip("LIST-STATUS extension is not implemented (need ListStatusUser interface)")
+		t.SkipNow()
+	}
+
+	setupListTree(t, u)
+
+	mbox, err := u.GetMailbox("Foo.Bar")
+	assert.NilError(t, err)
+	createMsgs(t, mbox, 3)
+	seq, _ := imap.ParseSeqSet("1")
+	assert.NilError(t, mbox.UpdateMessagesFlags(false, seq, imap.AddFlags, []string{imap.SeenFlag}))
+
+	mbox, err = u.GetMailbox("Baz")
+	assert.NilError(t, err)
+	createMsgs(t, mbox, 1)
+
+	items := []imap.StatusItem{imap.StatusMessages, imap.StatusRecent, imap.StatusUnseen, imap.StatusUidNext, imap.StatusUidValidity}
+
+	checkStatus := func(t *testing.T, infos []ListInfo) {
+		for _, info := range infos {
+			if hasAttr(info.Attributes, imap.NoSelectAttr) || hasAttr(info.Attributes, NonExistentAttr) {
+				assert.Check(t, info.Status == nil, "Status returned for non-selectable mailbox %s", info.Name)
+				continue
+			}
+			if !assert.Check(t, info.Status != nil, "Missing status for %s", info.Name) {
+				continue
+			}
+
+			mbox, err := u.GetMailbox(info.Name)
+			assert.NilError(t, err)
+			status, err := mbox.Status(items)
+			assert.NilError(t, err)
+
+			assert.Check(t, is.Equal(info.Status.Messages, status.Messages), "MESSAGES mismatch for %s", info.Name)
+			assert.Check(t, is.Equal(info.Status.Recent, status.Recent), "RECENT mismatch for %s", info.Name)
+			assert.Check(t, is.Equal(info.Status.Unseen, status.Unseen), "UNSEEN mismatch for %s", info.Name)
+			assert.Check(t, is.Equal(info.Status.UidNext, status.UidNext), "UIDNEXT mismatch for %s", info.Name)
+			assert.Check(t, is.Equal(info.Status.UidValidity, status.UidValidity), "UIDVALIDITY mismatch for %s", info.Name)
+		}
+	}
+
+	t.Run("All mailboxes", func(t *testing.T) {
+		skipIfExcluded(t)
+
+		infos, err := lsUser.ListMailboxesStatus("", []string{"*"}, &ListOptions{}, items)
+		assert.NilError(t, err)
+		assert.DeepEqual(t, listInfoNames(infos), mailboxNames(t, u, false))
+		checkStatus(t, infos)
+	})
+	t.Run("SUBSCRIBED", func(t *testing.T) {
+		skipIfExcluded(t)
+
+		infos, err := lsUser.ListMailboxesStatus("", []string{"*"}, &ListOptions{Subscribed: true}, items)
+		assert.NilError(t, err)
+		assert.DeepEqual(t, listInfoNames(infos), []string{"Baz", "Foo.Bar", "Gone"})
+		checkStatus(t, infos)
+	})
+}
@@ -0,0 +1,183 @@
+package backendtests
+
+import (
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/emersion/go-imap"
+	"gotest.tools/assert"
+)
+
+// matchMailboxPattern is the reference implementation of LIST pattern
+// matching (RFC 3501, Section 6.3.8).
+//
+// Pattern is appended to reference name as is. Name of INBOX is
+// case-insensitive, all other names are matched case-sensitively.
+func matchMailboxPattern(delim, ref, pattern, name string) bool {
+	pattern = ref + pattern
+	if strings.EqualFold(pattern, imap.InboxName) {
+		pattern = imap.InboxName
+	}
+
+	var delimRune rune
+	if delim != "" {
+		delimRune = []rune(delim)[0]
+	}
+	return matchWildcards([]rune(name), []rune(pattern), delimRune)
+}
+
+func matchWildcards(name, pattern []rune, delim rune) bool {
+	for len(pattern) != 0 {
+		if pattern[0] != '*' && pattern[0] != '%' {
+			if len(name) == 0 || name[0] != pattern[0] {
+				return false
+			}
+			name, pattern = name[1:], pattern[1:]
+			continue
+		}
+
+		for i := 0; i <= len(name); i++ {
+			if matchWildcards(name[i:], pattern[1:], delim) {
+				return true
+			}
+			// % doesn't match hierarchy delimiter.
+			if i < len(name) && pattern[0] == '%' && name[i] == delim {
+				return false
+			}
+		}
+		return false
+	}
+	return len(name) == 0
+}
+
+var listPatternTree = []string{
+	"INBOX.Sent",
+	"INBOX.Drafts",
+	"Archive.2019.01",
+	"Archive.2019.02",
+	"Archive.2020.01",
+	"Work.Projects.Alpha",
+	"Work.Projects.Beta",
+	"Work.Team",
+	"Входящие.Архив",
+	"Ünïcødé",
+	"Résumé.Café",
+}
+
+var listPatternSubscribed = []string{
+	"INBOX.Sent",
+	"Archive.2019",
+	"Work.Projects.Beta",
+	"Входящие.Архив",
+}
+
+var listPatternTests = []struct {
+	ref     string
+	pattern string
+}{
+	{"", "*"},
+	{"", "%"},
+	{"", "%.%"},
+	{"", "%.%.%"},
+	{"", "*.01"},
+	{"", "Archive"},
+	{"", "Archive*"},
+	{"", "Archive%"},
+	{"", "Archive.*"},
+	{"", "Archive.%"},
+	{"", "Archive.%.01"},
+	{"", "Archive.20%"},
+	{"", "Archive.2019.01"},
+	{"", "Arch"},
+	{"", "Work.Projects"},
+	{"", "Work.%.Alpha"},
+	{"", "W*a"},
+	{"", "W%a"},
+	{"", "*s*"},
+	{"Archive.", "%"},
+	{"Archive.", "*"},
+	{"Archive.2019.", "0%"},
+	{"Work.", "Projects.%"},
+	{"Work.", "%"},
+	{"Nonexistent.", "*"},
+	{"", "INBOX"},
+	{"", "inbox"},
+	{"", "InBoX"},
+	{"", "INBOX.%"},
+	{"", "INBOX*"},
+	{"", "I%"},
+	{"", "archive"},
+	{"", "Входящие"},
+	{"", "Входящие.%"},
+	{"", "*Архив"},
+	{"", "%Архив"},
+	{"Входящие.", "%"},
+	{"", "Ü%"},
+	{"", "*é"},
+	{"", "R*.Caf%"},
+}
+
+func User_ListPattern(t *testing.T, newBack NewBackFunc, closeBack CloseBackFunc) {
+	b := newBack()
+	defer closeBack(b)
+	u := getUser(t, b)
+	defer assert.NilError(t, u.Logout())
+
+	pl, ok := u.(PatternLister)
+	if !ok {
+		t.Skip("Backend doesn't match LIST patterns itself (need PatternLister interface)")
+		t.SkipNow()
+	}
+
+	for _, name := range listPatternTree {
+		assert.NilError(t, u.CreateMailbox(name))
+	}
+	for _, name := range listPatternSubscribed {
+		mbox, err := u.GetMailbox(name)
+		assert.NilError(t, err)
+		assert.NilError(t, mbox.SetSubscribed(true))
+	}
+
+	inbox, err := u.GetMailbox(imap.InboxName)
+	assert.NilError(t, err)
+	info, err := inbox.Info()
+	assert.NilError(t, err)
+	delim := info.Delimiter
+
+	testPattern := func(subscribed bool, ref, pattern string) {
+		name := "ref=" + ref + " pattern=" + pattern
+		if subscribed {
+			name = "LSUB " + name
+		} else {
+			name = "LIST " + name
+		}
+
+		t.Run(name, func(t *testing.T) {
+			skipIfExcluded(t)
+
+			expected := []string{}
+			for _, name := range mailboxNames(t, u, subscribed) {
+				if matchMailboxPattern(delim, ref, pattern, name) {
+					expected = append(expected, name)
+				}
+			}
+
+			mboxes, err := pl.ListMailboxesPattern(subscribed, ref, pattern)
+			assert.NilError(t, err)
+			actual := []string{}
+			for _, mbox := range mboxes {
+				actual = append(actual, mbox.Name())
+			}
+			sort.Strings(actual)
+
+			assert.DeepEqual(t, actual, expected)
+		})
+	}
+
+	for _, subscribed := range []bool{false, true} {
+		for _, case_ := range listPatternTests {
+			testPattern(subscribed, case_.ref, case_.pattern)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package backendtests
+
+import "errors"
+
+// Errors that should be returned by Backend and UserAdminBackend methods.
+//
+// Backend implementing UserAdminBackend should return these errors (possibly
+// wrapped, they are checked using errors.Is). Other backends are free to
+// return any non-nil error.
+var (
+	ErrUserAlreadyExists = errors.New("imap: user already exists")
+	ErrUserDoesNotExist  = errors.New("imap: user does not exist")
+)
+
+// UserAdminBackend is extension for Backend interface which allows to
+// manage user accounts for testing and administration purposes.
+type UserAdminBackend interface {
+	Backend
+
+	// DeleteUser removes user account with all mailboxes and messages.
+	DeleteUser(username string) error
+
+	// ListUsers returns names of all existing users.
+	ListUsers() ([]string, error)
+
+	// SetUserPassword sets new password for existing user.
+	SetUserPassword(username, password string) error
+
+	// CheckPlain returns true if password is valid for specified user.
+	CheckPlain(username, password string) bool
+}
@@ -17,7 +17,7 @@ import (
 	is "gotest.tools/assert/cmp"
 )
 
-func getNamedUser(t *testing.T, b Backend, name string) backend.User {
+func getNamedUser(t testing.TB, b Backend, name string) backend.User {
 	t.Helper()
 	err := b.CreateUser(name)
 	assert.NilError(t, err)
@@ -26,13 +26,13 @@ func getNamedUser(t *testing.T, b Backend, name string) backend.User {
 	return u
 }
 
-func getUser(t *testing.T, b Backend) backend.User {
+func getUser(t testing.TB, b Backend) backend.User {
 	t.Helper()
 	name := fmt.Sprintf("test%v", time.Now().UnixNano())
 	return getNamedUser(t, b, name)
 }
 
-func getNamedMbox(t *testing.T, u backend.User, name string) backend.Mailbox {
+func getNamedMbox(t testing.TB, u backend.User, name string) backend.Mailbox {
 	t.Helper()
 	assert.NilError(t, u.CreateMailbox(name))
 	mbox, err := u.GetMailbox(name)
@@ -40,7 +40,7 @@ func getNamedMbox(t *testing.T, u backend.User, name string) backend.Mailbox {
 	return mbox
 }
 
-func getMbox(t *testing.T, u backend.User) backend.Mailbox {
+func getMbox(t testing.TB, u backend.User) backend.Mailbox {
 	t.Helper()
 	name := fmt.Sprintf("test%v", time.Now().UnixNano())
 	return getNamedMbox(t, u, name)
@@ -48,7 +48,7 @@ func getMbox(t *testing.T, u backend.User) backend.Mailbox {
 
 var baseDate = time.Time{}
 
-func createMsgs(t *testing.T, mbox backend.Mailbox, count int) {
+func createMsgs(t testing.TB, mbox backend.Mailbox, count int) {
 	t.Helper()
 	for i := 0; i < count; i++ {
 		assert.NilError(t, mbox.CreateMessage(
@@ -100,6 +100,28 @@ func isNthMsgFlags(msg *imap.Message, indx int, args ...cmp.Option) is.Compariso
 	return is.DeepEqual(msg.Flags, []string{flags[0], flags[1], imap.RecentFlag})
 }
 
+func mailboxNames(t *testing.T, u backend.User, subscribed bool) []string {
+	t.Helper()
+	mboxes, err := u.ListMailboxes(subscribed)
+	assert.NilError(t, err)
+	names := make([]string, 0, len(mboxes))
+	for _, mbox := range mboxes {
+		names = append(names, mbox.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+func hasAttr(attrs []string, attr string) bool {
+	for _, a := range attrs {
+		// Attributes are case-insensitive.
+		if strings.EqualFold(a, attr) {
+			return true
+		}
+	}
+	return false
+}
+
 func init() {
 	if os.Getenv("SHUFFLE_CASES") == "1" {
 		rand.Seed(time.Now().Unix())
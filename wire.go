@@ -0,0 +1,266 @@
+package backendtests
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"log"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap"
+	move "github.com/emersion/go-imap-move"
+	"github.com/emersion/go-imap/backend"
+	"github.com/emersion/go-imap/client"
+	"github.com/emersion/go-imap/server"
+)
+
+// WireTimeout is the maximum amount of time wire-level tests wait for
+// a single IMAP command to complete. Commands that block longer than that
+// (e.g. because backend never closed ListMessages channel) fail.
+// It is scaled by TimeoutScale.
+var WireTimeout = 10 * time.Second
+
+// wirePassword is used by wire-level tests to log in, it is ignored by
+// loginBackend.
+const wirePassword = "wire-password"
+
+// WireExcluded lists tests (names relative to RunTests) that are not run by
+// RunWireTests because results are changed by go-imap server or client in
+// a way that can't be undone by wireUser. They should be skipped too when
+// testing a server through RemoteBackend.
+var WireExcluded = append([]string{
+	// go-imap client decodes MIME encoded-words in ENVELOPE.
+	"Mailbox_FetchEncoded/envelope",
+}, corpusTests(wireExcludedCorpus)...)
+
+// wireExcludedCorpus lists checks of corpus messages excluded from wire-level
+// tests, see WireExcluded.
+var wireExcludedCorpus = []string{
+	// go-imap client decodes MIME encoded-words in ENVELOPE...
+	"rfc2047-addresses/envelope",
+	"rfc2047-adjacent/envelope",
+	"rfc2047-subject/envelope",
+	// ... and in body description.
+	"content-id-description/bodystructure",
+
+	// go-imap server crashes if backend returns nil BODYSTRUCTURE, it is
+	// checked by RunTests.
+	"multipart-missing-end/bodystructure",
+	"multipart-no-parts/bodystructure",
+}
+
+// corpusTests returns names of tests that check messages from corpus, checks
+// are specified as "NAME/CHECK".
+func corpusTests(checks []string) []string {
+	var tests []string
+	for _, check := range checks {
+		tests = append(tests,
+			"Mailbox_ListMessages_Corpus/"+check,
+			"Mailbox_Differential/fetch/"+check,
+		)
+	}
+	return tests
+}
+
+// loginBackend implements backend.Backend on top of tested Backend
+// without authentication, passwords are checked by Backend_Login instead.
+type loginBackend struct {
+	Backend
+}
+
+func (b loginBackend) Login(_ *imap.ConnInfo, username, _ string) (backend.User, error) {
+	return b.GetUser(username)
+}
+
+// updaterLoginBackend is used instead of loginBackend if tested Backend
+// implements backend.BackendUpdater, this way server delivers backend
+// updates to connected clients.
+type updaterLoginBackend struct {
+	loginBackend
+	backend.BackendUpdater
+}
+
+// wireBackend wraps tested Backend into go-imap server running on
+// loopback interface. User objects returned by wireBackend execute all
+// operations using go-imap client connected to this server.
+type wireBackend struct {
+	Backend
+
+	srv      *server.Server
+	listener net.Listener
+	err      error
+
+	clients clientSet
+
+	// stopDrain is closed to stop goroutine discarding backend updates.
+	stopDrain chan struct{}
+}
+
+// newWireBackend creates wireBackend for tested Backend. If updates is false,
+// server doesn't deliver unilateral updates to clients and sends responses
+// for own changes synchronously instead, backend updates are discarded.
+func newWireBackend(b Backend, updates bool) *wireBackend {
+	wb := &wireBackend{Backend: b}
+
+	var imapBack backend.Backend = loginBackend{b}
+	if updater, ok := b.(backend.BackendUpdater); ok {
+		if updates {
+			imapBack = updaterLoginBackend{loginBackend{b}, updater}
+		} else {
+			wb.stopDrain = make(chan struct{})
+			go drainUpdates(updater.Updates(), wb.stopDrain)
+		}
+	}
+
+	wb.srv = server.New(imapBack)
+	wb.srv.AllowInsecureAuth = true
+	wb.srv.ErrorLog = log.New(ioutil.Discard, "", 0)
+	wb.srv.Enable(move.NewExtension())
+
+	wb.listener, wb.err = net.Listen("tcp", "127.0.0.1:0")
+	if wb.err != nil {
+		return wb
+	}
+	go wb.srv.Serve(wb.listener)
+
+	return wb
+}
+
+func drainUpdates(upds <-chan backend.Update, stop <-chan struct{}) {
+	for {
+		select {
+		case upd := <-upds:
+			if upd == nil {
+				return
+			}
+			close(upd.Done())
+		case <-stop:
+			return
+		}
+	}
+}
+
+// clientSet keeps track of client connections to close them all at once.
+type clientSet struct {
+	lock    sync.Mutex
+	clients []*client.Client
+}
+
+// dial creates new client connection authenticated using specified
+// credentials. If tlsConfig is not nil, implicit TLS is used.
+//
+// Connection is closed by close.
+func (cs *clientSet) dial(addr string, tlsConfig *tls.Config, username, password string) (*client.Client, error) {
+	var (
+		c   *client.Client
+		err error
+	)
+	if tlsConfig != nil {
+		c, err = client.DialTLS(addr, tlsConfig)
+	} else {
+		c, err = client.Dial(addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	c.ErrorLog = log.New(ioutil.Discard, "", 0)
+	c.Timeout = scaled(WireTimeout)
+
+	cs.lock.Lock()
+	cs.clients = append(cs.clients, c)
+	cs.lock.Unlock()
+
+	if err := c.Login(username, password); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (cs *clientSet) close() {
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+	for _, c := range cs.clients {
+		c.Terminate()
+	}
+	cs.clients = nil
+}
+
+// dial creates new client connection authenticated as specified user.
+//
+// Connection is closed by Close.
+func (wb *wireBackend) dial(username string) (*client.Client, error) {
+	if wb.err != nil {
+		return nil, wb.err
+	}
+	return wb.clients.dial(wb.listener.Addr().String(), nil, username, wirePassword)
+}
+
+func (wb *wireBackend) GetUser(username string) (backend.User, error) {
+	direct, err := wb.Backend.GetUser(username)
+	if err != nil {
+		return nil, err
+	}
+	c, err := wb.dial(username)
+	if err != nil {
+		return nil, err
+	}
+	return &wireUser{c: c, username: username, direct: direct}, nil
+}
+
+// Close terminates all client connections and stops the server. Tested
+// backend is not closed.
+func (wb *wireBackend) Close() error {
+	wb.clients.close()
+
+	if wb.stopDrain != nil {
+		close(wb.stopDrain)
+	}
+
+	if wb.err != nil {
+		return wb.err
+	}
+	return wb.srv.Close()
+}
+
+// RunWireTests runs all tests from RunTests but executes all backend
+// operations through in-process go-imap server and client, it allows
+// to catch bugs in backend interaction with go-imap server package
+// (e.g. incorrect literals handling or not closed ListMessages channel).
+//
+// Additionally, tests comparing wire-level results with direct calls results
+// and tests for unilateral updates delivery to clients are executed.
+//
+// Tests requiring optional interfaces (including backend.BackendUpdater) are
+// skipped in wire mode, except for wire-specific ones.
+func RunWireTests(t *testing.T, newBackend NewBackFunc, closeBackend CloseBackFunc) {
+	newWire := func() Backend {
+		return newWireBackend(newBackend(), true)
+	}
+	closeWire := func(b Backend) {
+		wb := b.(*wireBackend)
+		wb.Close()
+		closeBackend(wb.Backend)
+	}
+
+	t.Run("Wire", func(t *testing.T) {
+		skipIfExcluded(t)
+		defer excludeTests(t.Name(), WireExcluded)()
+		RunTests(t, newWire, closeWire)
+	})
+
+	addTest := func(f testFunc) {
+		t.Run(getFunctionName(f), func(t *testing.T) {
+			if Report != nil {
+				defer Report.record(t, time.Now())
+			}
+			skipIfExcluded(t)
+			f(t, newBackend, closeBackend)
+		})
+	}
+
+	addTest(Wire_Compare)
+	addTest(Wire_Updates)
+	addTest(Wire_Transcripts)
+}
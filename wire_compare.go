@@ -0,0 +1,462 @@
+package backendtests
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap"
+	move "github.com/emersion/go-imap-move"
+	"github.com/emersion/go-imap/backend"
+	"github.com/emersion/go-imap/client"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+// wireMessage is a comparable representation of imap.Message.
+type wireMessage struct {
+	SeqNum        uint32
+	Uid           uint32
+	Flags         []string
+	InternalDate  time.Time
+	Size          uint32
+	Envelope      *imap.Envelope
+	BodyStructure *imap.BodyStructure
+	Body          map[string]string
+}
+
+// sentBodyStructure returns copy of bs without fields that are not sent
+// over the wire in non-extended body structure (BODY item).
+func sentBodyStructure(bs *imap.BodyStructure) *imap.BodyStructure {
+	if bs == nil {
+		return nil
+	}
+
+	res := *bs
+	if !bs.Extended {
+		if bs.MIMEType == "multipart" {
+			res.Params = nil
+		}
+		res.MD5 = ""
+		res.Disposition = ""
+		res.DispositionParams = nil
+		res.Language = nil
+		res.Location = nil
+	}
+
+	res.Parts = nil
+	for _, part := range bs.Parts {
+		res.Parts = append(res.Parts, sentBodyStructure(part))
+	}
+	res.BodyStructure = sentBodyStructure(bs.BodyStructure)
+	return &res
+}
+
+// fetchCompare fetches messages and converts them into wireMessage.
+//
+// \Recent flag is removed since SELECT executed by wire-level mailbox may
+// reset it, other flags are converted to lower case since go-imap does so
+// for keywords. InternalDate is truncated to seconds since it is sent with
+// seconds precision.
+func fetchCompare(t *testing.T, mbox backend.Mailbox, uid bool, seqset *imap.SeqSet, items []imap.FetchItem) []wireMessage {
+	t.Helper()
+
+	ch := make(chan *imap.Message, 100)
+	assert.NilError(t, mbox.ListMessages(uid, seqset, items, ch))
+
+	var res []wireMessage
+	for msg := range ch {
+		flags := make([]string, 0, len(msg.Flags))
+		for _, flag := range msg.Flags {
+			if flag != imap.RecentFlag {
+				flags = append(flags, strings.ToLower(flag))
+			}
+		}
+		sort.Strings(flags)
+
+		body := make(map[string]string, len(msg.Body))
+		for section, literal := range msg.Body {
+			b, err := ioutil.ReadAll(literal)
+			assert.NilError(t, err)
+			body[string(section.FetchItem())] = string(b)
+		}
+
+		res = append(res, wireMessage{
+			SeqNum:        msg.SeqNum,
+			Uid:           msg.Uid,
+			Flags:         flags,
+			InternalDate:  msg.InternalDate.Truncate(time.Second),
+			Size:          msg.Size,
+			Envelope:      msg.Envelope,
+			BodyStructure: sentBodyStructure(msg.BodyStructure),
+			Body:          body,
+		})
+	}
+	return res
+}
+
+// wireSnapshot returns state of mailbox that should be the same for
+// mailboxes that got the same sequence of operations.
+//
+// UIDs are not included, since they can differ between mailboxes.
+func wireSnapshot(t *testing.T, mbox backend.Mailbox) ([]wireMessage, []uint32) {
+	t.Helper()
+
+	seq, _ := imap.ParseSeqSet("1:*")
+	msgs := fetchCompare(t, mbox, false, seq, []imap.FetchItem{imap.FetchFlags, imap.FetchInternalDate, imap.FetchRFC822Size})
+
+	status, err := mbox.Status([]imap.StatusItem{imap.StatusMessages, imap.StatusUnseen})
+	assert.NilError(t, err)
+	return msgs, []uint32{status.Messages, status.Unseen}
+}
+
+// nthUid returns UID of the message with specified sequence number.
+func nthUid(t *testing.T, mbox backend.Mailbox, seqNum uint32) *imap.SeqSet {
+	t.Helper()
+
+	seq := &imap.SeqSet{}
+	seq.AddNum(seqNum)
+	msgs := fetchCompare(t, mbox, false, seq, []imap.FetchItem{imap.FetchUid})
+	assert.Assert(t, is.Len(msgs, 1))
+
+	uidSeq := &imap.SeqSet{}
+	uidSeq.AddNum(msgs[0].Uid)
+	return uidSeq
+}
+
+var wireFetchItems = [][]imap.FetchItem{
+	{imap.FetchUid, imap.FetchFlags},
+	{imap.FetchInternalDate, imap.FetchRFC822Size},
+	{imap.FetchEnvelope},
+	{imap.FetchBody},
+	{imap.FetchBodyStructure},
+	{"BODY.PEEK[]"},
+	{"BODY.PEEK[HEADER]"},
+	{"BODY.PEEK[TEXT]"},
+	{"BODY.PEEK[1]"},
+	{"BODY.PEEK[1.MIME]"},
+	{"BODY.PEEK[2]"},
+	{"BODY.PEEK[HEADER.FIELDS (From To)]"},
+	{"BODY.PEEK[HEADER.FIELDS.NOT (From To)]"},
+	{"BODY.PEEK[]<0.10>"},
+	{"BODY.PEEK[TEXT]<5.1000>"},
+	{imap.FetchFlags, imap.FetchInternalDate, imap.FetchRFC822Size, imap.FetchEnvelope, imap.FetchBody},
+}
+
+var wireSearchCriteria = []*imap.SearchCriteria{
+	{},
+	{WithFlags: []string{imap.SeenFlag}},
+	{WithoutFlags: []string{imap.SeenFlag}},
+	{WithFlags: []string{"$wire"}},
+	{Header: map[string][]string{"From": {"Mitsuha"}}},
+	{Body: []string{"name"}},
+	{Text: []string{"Mitsuha"}},
+	{Larger: 10},
+	{Smaller: 10},
+	{Since: baseDate.Add(48 * time.Hour)},
+	{Not: []*imap.SearchCriteria{{WithFlags: []string{imap.SeenFlag}}}},
+	{Or: [][2]*imap.SearchCriteria{{{WithFlags: []string{imap.SeenFlag}}, {WithFlags: []string{"$wire"}}}}},
+}
+
+func Wire_Compare(t *testing.T, newBack NewBackFunc, closeBack CloseBackFunc) {
+	b := newBack()
+	defer closeBack(b)
+	wb := newWireBackend(b, true)
+	defer wb.Close()
+
+	u := getNamedUser(t, b, "username1")
+	defer assert.NilError(t, u.Logout())
+	wu, err := wb.GetUser("username1")
+	assert.NilError(t, err)
+
+	mbox := getNamedMbox(t, u, "TEST")
+	createMsgs(t, mbox, 5)
+	seq, _ := imap.ParseSeqSet("2:3")
+	assert.NilError(t, mbox.UpdateMessagesFlags(false, seq, imap.AddFlags, []string{imap.SeenFlag}))
+	// go-imap converts keywords to lower case so keywords used in
+	// operations are lower case too, otherwise results would differ for
+	// backends that compare flags case-sensitively.
+	seq, _ = imap.ParseSeqSet("3:4")
+	assert.NilError(t, mbox.UpdateMessagesFlags(false, seq, imap.AddFlags, []string{"$wire"}))
+
+	wMbox, err := wu.GetMailbox("TEST")
+	assert.NilError(t, err)
+
+	t.Run("Fetch", func(t *testing.T) {
+		skipIfExcluded(t)
+
+		for _, items := range wireFetchItems {
+			for _, uid := range []bool{false, true} {
+				if uid {
+					// Server always returns UID for UID FETCH.
+					items = append(items[:len(items):len(items)], imap.FetchUid)
+				}
+
+				seq, _ := imap.ParseSeqSet("1:*")
+				direct := fetchCompare(t, mbox, uid, seq, items)
+				wire := fetchCompare(t, wMbox, uid, seq, items)
+				assert.Check(t, is.DeepEqual(wire, direct, cmpopts.EquateEmpty()), "Wire-level result mismatch for %v (uid = %v)", items, uid)
+			}
+		}
+	})
+	t.Run("Search", func(t *testing.T) {
+		skipIfExcluded(t)
+
+		for _, criteria := range wireSearchCriteria {
+			for _, uid := range []bool{false, true} {
+				direct, err := mbox.SearchMessages(uid, criteria)
+				assert.NilError(t, err)
+				wire, err := wMbox.SearchMessages(uid, criteria)
+				assert.NilError(t, err)
+				assert.Check(t, is.DeepEqual(wire, direct, cmpopts.EquateEmpty()), "Wire-level result mismatch for %+v (uid = %v)", criteria, uid)
+			}
+		}
+	})
+	t.Run("Status", func(t *testing.T) {
+		skipIfExcluded(t)
+
+		items := []imap.StatusItem{imap.StatusMessages, imap.StatusUnseen, imap.StatusUidNext, imap.StatusUidValidity}
+		direct, err := mbox.Status(items)
+		assert.NilError(t, err)
+		wire, err := wMbox.Status(items)
+		assert.NilError(t, err)
+
+		assert.Check(t, is.Equal(wire.Messages, direct.Messages), "MESSAGES mismatch")
+		assert.Check(t, is.Equal(wire.Unseen, direct.Unseen), "UNSEEN mismatch")
+		assert.Check(t, is.Equal(wire.UidNext, direct.UidNext), "UIDNEXT mismatch")
+		assert.Check(t, is.Equal(wire.UidValidity, direct.UidValidity), "UIDVALIDITY mismatch")
+	})
+	t.Run("Operations", func(t *testing.T) {
+		skipIfExcluded(t)
+
+		// Same operations are applied to two identical mailboxes, one
+		// using direct calls and another one using wire-level mailbox.
+		type mboxSet struct {
+			src, copyTgt, moveTgt backend.Mailbox
+		}
+		sets := make([]mboxSet, 0, 2)
+		for _, prefix := range []string{"DIRECT", "WIRE"} {
+			src := getNamedMbox(t, u, prefix)
+			createMsgs(t, src, 6)
+			assert.NilError(t, u.CreateMailbox(prefix+"-COPY"))
+			assert.NilError(t, u.CreateMailbox(prefix+"-MOVE"))
+
+			userForOps := u
+			if prefix == "WIRE" {
+				userForOps = wu
+			}
+			var set mboxSet
+			set.src, err = userForOps.GetMailbox(prefix)
+			assert.NilError(t, err)
+			set.copyTgt, err = userForOps.GetMailbox(prefix + "-COPY")
+			assert.NilError(t, err)
+			set.moveTgt, err = userForOps.GetMailbox(prefix + "-MOVE")
+			assert.NilError(t, err)
+			sets = append(sets, set)
+		}
+		direct, wire := sets[0], sets[1]
+
+		steps := []struct {
+			name string
+			op   func(set mboxSet, copyTgt, moveTgt string) error
+		}{
+			{"STORE +FLAGS", func(set mboxSet, _, _ string) error {
+				seq, _ := imap.ParseSeqSet("1:2")
+				return set.src.UpdateMessagesFlags(false, seq, imap.AddFlags, []string{imap.SeenFlag, imap.FlaggedFlag, "$wire"})
+			}},
+			{"STORE -FLAGS", func(set mboxSet, _, _ string) error {
+				seq, _ := imap.ParseSeqSet("2:*")
+				return set.src.UpdateMessagesFlags(false, seq, imap.RemoveFlags, []string{imap.FlaggedFlag, "$wire"})
+			}},
+			{"UID STORE FLAGS", func(set mboxSet, _, _ string) error {
+				return set.src.UpdateMessagesFlags(true, nthUid(t, set.src, 4), imap.SetFlags, []string{imap.AnsweredFlag})
+			}},
+			{"COPY", func(set mboxSet, copyTgt, _ string) error {
+				seq, _ := imap.ParseSeqSet("1:3")
+				return set.src.CopyMessages(false, seq, copyTgt)
+			}},
+			{"UID COPY", func(set mboxSet, copyTgt, _ string) error {
+				return set.src.CopyMessages(true, nthUid(t, set.src, 5), copyTgt)
+			}},
+			{"EXPUNGE", func(set mboxSet, _, _ string) error {
+				seq, _ := imap.ParseSeqSet("2,4")
+				if err := set.src.UpdateMessagesFlags(false, seq, imap.AddFlags, []string{imap.DeletedFlag}); err != nil {
+					return err
+				}
+				return set.src.Expunge()
+			}},
+			{"MOVE", func(set mboxSet, _, moveTgt string) error {
+				moveMbox, ok := set.src.(move.Mailbox)
+				if !ok {
+					return nil
+				}
+				seq, _ := imap.ParseSeqSet("1")
+				return moveMbox.MoveMessages(false, seq, moveTgt)
+			}},
+			{"UID MOVE", func(set mboxSet, _, moveTgt string) error {
+				moveMbox, ok := set.src.(move.Mailbox)
+				if !ok {
+					return nil
+				}
+				return moveMbox.MoveMessages(true, nthUid(t, set.src, 2), moveTgt)
+			}},
+		}
+
+		for _, step := range steps {
+			directErr := step.op(direct, "DIRECT-COPY", "DIRECT-MOVE")
+			wireErr := step.op(wire, "WIRE-COPY", "WIRE-MOVE")
+			assert.NilError(t, directErr, step.name)
+			assert.NilError(t, wireErr, step.name)
+
+			for i, pair := range [][2]backend.Mailbox{
+				{direct.src, wire.src},
+				{direct.copyTgt, wire.copyTgt},
+				{direct.moveTgt, wire.moveTgt},
+			} {
+				directMsgs, directStatus := wireSnapshot(t, pair[0])
+				wireMsgs, wireStatus := wireSnapshot(t, pair[1])
+				assert.Check(t, is.DeepEqual(wireMsgs, directMsgs, cmpopts.EquateEmpty()), "Messages mismatch after %s (mailbox %d)", step.name, i)
+				assert.Check(t, is.DeepEqual(wireStatus, directStatus), "Status mismatch after %s (mailbox %d)", step.name, i)
+			}
+		}
+	})
+	t.Run("Errors", func(t *testing.T) {
+		skipIfExcluded(t)
+
+		_, err := wu.GetMailbox("NONEXISTENT")
+		assert.Error(t, err, backend.ErrNoSuchMailbox.Error())
+
+		seq, _ := imap.ParseSeqSet("1")
+		directErr := mbox.CopyMessages(false, seq, "NONEXISTENT")
+		wireErr := wMbox.CopyMessages(false, seq, "NONEXISTENT")
+		assert.Assert(t, directErr != nil)
+		assert.Assert(t, wireErr != nil)
+		assert.Check(t, is.Equal(wireErr.Error(), directErr.Error()), "Error text mismatch for COPY to non-existent mailbox")
+
+		directErr = u.CreateMailbox("TEST")
+		wireErr = wu.CreateMailbox("TEST")
+		assert.Assert(t, directErr != nil)
+		assert.Assert(t, wireErr != nil)
+		assert.Check(t, is.Equal(wireErr.Error(), directErr.Error()), "Error text mismatch for CREATE of existing mailbox")
+	})
+}
+
+// waitClientUpdate waits for update matching passed function, other updates
+// are ignored. expected describes the update test waits for and is reported
+// on timeout.
+//
+// Server may send updates only in response to a command, so NOOP is sent
+// periodically while waiting.
+func waitClientUpdate(t *testing.T, c *client.Client, upds <-chan client.Update, expected string, match func(client.Update) bool) {
+	t.Helper()
+
+	ctx, cancel := waitContext(t, UpdateTimeout)
+	defer cancel()
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	var received []string
+	assert.NilError(t, c.Noop())
+	for {
+		select {
+		case upd := <-upds:
+			if match(upd) {
+				return
+			}
+			received = append(received, fmt.Sprintf("%T", upd))
+		case <-ticker.C:
+			assert.NilError(t, c.Noop())
+		case <-ctx.Done():
+			t.Fatalf("%s is not delivered to client (%s), received other updates: %v", expected, waitError(ctx), received)
+		}
+	}
+}
+
+func Wire_Updates(t *testing.T, newBack NewBackFunc, closeBack CloseBackFunc) {
+	b := newBack()
+	defer closeBack(b)
+
+	if _, ok := b.(backend.BackendUpdater); !ok {
+		t.Skip("Backend doesn't supports unilateral updates (need backend.BackendUpdater interface)")
+		t.SkipNow()
+	}
+
+	wb := newWireBackend(b, true)
+	defer wb.Close()
+
+	u := getNamedUser(t, b, "username1")
+	defer assert.NilError(t, u.Logout())
+	assert.NilError(t, u.CreateMailbox("TEST"))
+
+	c, err := wb.dial("username1")
+	assert.NilError(t, err)
+	upds := make(chan client.Update, 100)
+	c.Updates = upds
+	_, err = c.Select("TEST", false)
+	assert.NilError(t, err)
+
+	// Changes are made using direct calls since go-imap server delivers
+	// FETCH and EXPUNGE updates to only one of connections with the same
+	// mailbox selected.
+	mbox, err := u.GetMailbox("TEST")
+	assert.NilError(t, err)
+
+	t.Run("APPEND", func(t *testing.T) {
+		skipIfExcluded(t)
+
+		createMsgs(t, mbox, 2)
+		waitClientUpdate(t, c, upds, "MailboxUpdate with 2 messages", func(upd client.Update) bool {
+			mboxUpd, ok := upd.(*client.MailboxUpdate)
+			return ok && mboxUpd.Mailbox.Messages == 2
+		})
+	})
+	t.Run("STORE", func(t *testing.T) {
+		skipIfExcluded(t)
+
+		seq, _ := imap.ParseSeqSet("2")
+		assert.NilError(t, mbox.UpdateMessagesFlags(false, seq, imap.AddFlags, []string{imap.FlaggedFlag}))
+		waitClientUpdate(t, c, upds, "MessageUpdate for message 2 with \\Flagged", func(upd client.Update) bool {
+			msgUpd, ok := upd.(*client.MessageUpdate)
+			return ok && msgUpd.Message.SeqNum == 2 && hasAttr(msgUpd.Message.Flags, imap.FlaggedFlag)
+		})
+	})
+	t.Run("EXPUNGE", func(t *testing.T) {
+		skipIfExcluded(t)
+
+		seq, _ := imap.ParseSeqSet("1")
+		assert.NilError(t, mbox.UpdateMessagesFlags(false, seq, imap.AddFlags, []string{imap.DeletedFlag}))
+		assert.NilError(t, mbox.Expunge())
+		waitClientUpdate(t, c, upds, "ExpungeUpdate for message 1", func(upd client.Update) bool {
+			expUpd, ok := upd.(*client.ExpungeUpdate)
+			return ok && expUpd.SeqNum == 1
+		})
+	})
+	t.Run("Other mailbox", func(t *testing.T) {
+		skipIfExcluded(t)
+
+		assert.NilError(t, u.CreateMailbox("TEST2"))
+		other, err := u.GetMailbox("TEST2")
+		assert.NilError(t, err)
+		createMsgs(t, other, 3)
+
+		assert.NilError(t, c.Noop())
+		// Updates are collected for some time, none of them should be for
+		// TEST2.
+		ctx, cancel := waitContext(t, UpdateTimeout/4)
+		defer cancel()
+		for {
+			select {
+			case upd := <-upds:
+				if mboxUpd, ok := upd.(*client.MailboxUpdate); ok {
+					assert.Check(t, mboxUpd.Mailbox.Messages != 3, "Update for another mailbox is delivered")
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	})
+}
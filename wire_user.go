@@ -0,0 +1,408 @@
+package backendtests
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/emersion/go-imap"
+	move "github.com/emersion/go-imap-move"
+	"github.com/emersion/go-imap/backend"
+	"github.com/emersion/go-imap/client"
+)
+
+// wireUser implements backend.User using go-imap client.
+type wireUser struct {
+	// lock serializes commands since mailbox operations require
+	// SELECT to be executed before them.
+	lock     sync.Mutex
+	c        *client.Client
+	username string
+
+	// direct is used only to check which extensions are supported by
+	// tested backend mailboxes. If it is nil, server capabilities are
+	// used instead.
+	direct backend.User
+
+	// flagsCase maps lower-case flags to the form they were passed in.
+	//
+	// go-imap converts keywords to lower case on the wire, tests expect
+	// to get flags exactly as they were set.
+	flagsCase map[string]string
+
+	// emptyNil makes ListMessages return empty body sections in place of
+	// ones server returned as NIL. Servers may return NIL for sections that
+	// don't exist, while tests expect empty sections. It is set by
+	// RemoteBackend.
+	emptyNil bool
+}
+
+// rememberFlags should be called with lock held.
+func (u *wireUser) rememberFlags(flags []string) {
+	if u.flagsCase == nil {
+		u.flagsCase = make(map[string]string)
+	}
+	for _, flag := range flags {
+		u.flagsCase[strings.ToLower(flag)] = flag
+	}
+}
+
+// restoreFlags should be called with lock held.
+func (u *wireUser) restoreFlags(flags []string) []string {
+	for i, flag := range flags {
+		if orig, ok := u.flagsCase[strings.ToLower(flag)]; ok {
+			flags[i] = orig
+		}
+	}
+	return flags
+}
+
+func (u *wireUser) newMailbox(name string, info *imap.MailboxInfo) backend.Mailbox {
+	mbox := &wireMailbox{u: u, name: name, info: info}
+	if u.direct == nil {
+		if ok, _ := u.c.Support(move.Capability); ok {
+			return &wireMoveMailbox{mbox}
+		}
+		return mbox
+	}
+	if directMbox, err := u.direct.GetMailbox(name); err == nil {
+		if _, ok := directMbox.(move.Mailbox); ok {
+			return &wireMoveMailbox{mbox}
+		}
+	}
+	return mbox
+}
+
+func (u *wireUser) Username() string {
+	return u.username
+}
+
+func (u *wireUser) list(subscribed bool, name string) ([]*imap.MailboxInfo, error) {
+	ch := make(chan *imap.MailboxInfo, 10)
+	done := make(chan error, 1)
+	go func() {
+		if subscribed {
+			done <- u.c.Lsub("", name, ch)
+		} else {
+			done <- u.c.List("", name, ch)
+		}
+	}()
+
+	var infos []*imap.MailboxInfo
+	for info := range ch {
+		infos = append(infos, info)
+	}
+	return infos, <-done
+}
+
+func (u *wireUser) ListMailboxes(subscribed bool) ([]backend.Mailbox, error) {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+
+	infos, err := u.list(subscribed, "*")
+	if err != nil {
+		return nil, err
+	}
+
+	mboxes := make([]backend.Mailbox, 0, len(infos))
+	for _, info := range infos {
+		mboxes = append(mboxes, u.newMailbox(info.Name, info))
+	}
+	return mboxes, nil
+}
+
+func (u *wireUser) GetMailbox(name string) (backend.Mailbox, error) {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+
+	infos, err := u.list(false, name)
+	if err != nil {
+		return nil, err
+	}
+	if len(infos) == 0 {
+		return nil, backend.ErrNoSuchMailbox
+	}
+	return u.newMailbox(infos[0].Name, nil), nil
+}
+
+func (u *wireUser) CreateMailbox(name string) error {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	return u.c.Create(name)
+}
+
+func (u *wireUser) DeleteMailbox(name string) error {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	return u.c.Delete(name)
+}
+
+func (u *wireUser) RenameMailbox(existingName, newName string) error {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	return u.c.Rename(existingName, newName)
+}
+
+// Logout does nothing, connection is closed by wireBackend.Close.
+//
+// Tests call Logout using defer without a closure, so it is actually called
+// right after user creation.
+func (u *wireUser) Logout() error {
+	return nil
+}
+
+// wireMailbox implements backend.Mailbox using go-imap client. Mailbox is
+// selected before each command that requires it.
+type wireMailbox struct {
+	u    *wireUser
+	name string
+
+	// info is set for mailboxes returned by ListMailboxes, it is
+	// returned by Info as is since mailbox may be not listed by LIST
+	// (e.g. non-existent subscribed mailbox).
+	info *imap.MailboxInfo
+}
+
+func (m *wireMailbox) Name() string {
+	return m.name
+}
+
+func (m *wireMailbox) Info() (*imap.MailboxInfo, error) {
+	if m.info != nil {
+		return m.info, nil
+	}
+
+	m.u.lock.Lock()
+	defer m.u.lock.Unlock()
+
+	infos, err := m.u.list(false, m.name)
+	if err != nil {
+		return nil, err
+	}
+	if len(infos) == 0 {
+		return nil, backend.ErrNoSuchMailbox
+	}
+	return infos[0], nil
+}
+
+func (m *wireMailbox) Status(items []imap.StatusItem) (*imap.MailboxStatus, error) {
+	m.u.lock.Lock()
+	defer m.u.lock.Unlock()
+
+	// FLAGS, PERMANENTFLAGS and first unseen message are returned only in
+	// SELECT response.
+	selected, err := m.u.c.Select(m.name, true)
+	if err != nil {
+		return nil, err
+	}
+
+	status := imap.NewMailboxStatus(m.name, items)
+	if len(items) != 0 {
+		status, err = m.u.c.Status(m.name, items)
+		if err != nil {
+			return nil, err
+		}
+	}
+	status.Flags = m.u.restoreFlags(selected.Flags)
+	status.PermanentFlags = m.u.restoreFlags(selected.PermanentFlags)
+	status.UnseenSeqNum = selected.UnseenSeqNum
+	return status, nil
+}
+
+func (m *wireMailbox) SetSubscribed(subscribed bool) error {
+	m.u.lock.Lock()
+	defer m.u.lock.Unlock()
+	if subscribed {
+		return m.u.c.Subscribe(m.name)
+	}
+	return m.u.c.Unsubscribe(m.name)
+}
+
+// selectMbox should be called with m.u.lock held.
+func (m *wireMailbox) selectMbox() error {
+	_, err := m.u.c.Select(m.name, false)
+	return err
+}
+
+func (m *wireMailbox) Check() error {
+	m.u.lock.Lock()
+	defer m.u.lock.Unlock()
+	if err := m.selectMbox(); err != nil {
+		return err
+	}
+	return m.u.c.Check()
+}
+
+// requestedSection returns body section from items that has the same
+// response name as returned section.
+func requestedSection(items []imap.FetchItem, returned *imap.BodySectionName) *imap.BodySectionName {
+	for _, item := range items {
+		section, err := imap.ParseBodySectionName(item)
+		if err != nil {
+			continue
+		}
+		resp := *section
+		resp.Peek = false
+		if len(resp.Partial) == 2 {
+			resp.Partial = resp.Partial[:1]
+		}
+		if returned.Equal(&resp) {
+			return section
+		}
+	}
+	return returned
+}
+
+func (m *wireMailbox) ListMessages(uid bool, seqset *imap.SeqSet, items []imap.FetchItem, ch chan<- *imap.Message) error {
+	defer close(ch)
+
+	m.u.lock.Lock()
+	defer m.u.lock.Unlock()
+	if err := m.selectMbox(); err != nil {
+		return err
+	}
+
+	msgs := make(chan *imap.Message, 10)
+	done := make(chan error, 1)
+	go func() {
+		if uid {
+			done <- m.u.c.UidFetch(seqset, items, msgs)
+		} else {
+			done <- m.u.c.Fetch(seqset, items, msgs)
+		}
+	}()
+
+	for msg := range msgs {
+		// Server returns BODY[] for BODY.PEEK[] and partial ranges without
+		// length, while direct calls return sections exactly as requested.
+		body := make(map[*imap.BodySectionName]imap.Literal, len(msg.Body))
+		for section, literal := range msg.Body {
+			if literal == nil && m.u.emptyNil {
+				literal = bytes.NewReader(nil)
+			}
+			body[requestedSection(items, section)] = literal
+		}
+		msg.Body = body
+		msg.Flags = m.u.restoreFlags(msg.Flags)
+
+		ch <- msg
+	}
+	return <-done
+}
+
+// wireCriteria returns copy of criteria that can be sent to server.
+//
+// Empty sequence set matches no messages when passed to SearchMessages
+// directly, but can't be represented on the wire. Such sets are replaced with
+// NOT 1:* that has the same meaning.
+func wireCriteria(criteria *imap.SearchCriteria) *imap.SearchCriteria {
+	if criteria == nil {
+		return nil
+	}
+
+	c := *criteria
+	c.Not = make([]*imap.SearchCriteria, 0, len(criteria.Not))
+	for _, not := range criteria.Not {
+		c.Not = append(c.Not, wireCriteria(not))
+	}
+	c.Or = make([][2]*imap.SearchCriteria, 0, len(criteria.Or))
+	for _, or := range criteria.Or {
+		c.Or = append(c.Or, [2]*imap.SearchCriteria{wireCriteria(or[0]), wireCriteria(or[1])})
+	}
+
+	matchNone := false
+	if c.SeqNum != nil && c.SeqNum.Empty() {
+		c.SeqNum = nil
+		matchNone = true
+	}
+	if c.Uid != nil && c.Uid.Empty() {
+		c.Uid = nil
+		matchNone = true
+	}
+	if matchNone {
+		all, _ := imap.ParseSeqSet("1:*")
+		c.Not = append(c.Not, &imap.SearchCriteria{SeqNum: all})
+	}
+	return &c
+}
+
+func (m *wireMailbox) SearchMessages(uid bool, criteria *imap.SearchCriteria) ([]uint32, error) {
+	m.u.lock.Lock()
+	defer m.u.lock.Unlock()
+	if err := m.selectMbox(); err != nil {
+		return nil, err
+	}
+	criteria = wireCriteria(criteria)
+	if uid {
+		return m.u.c.UidSearch(criteria)
+	}
+	return m.u.c.Search(criteria)
+}
+
+func (m *wireMailbox) CreateMessage(flags []string, date time.Time, body imap.Literal) error {
+	m.u.lock.Lock()
+	defer m.u.lock.Unlock()
+	m.u.rememberFlags(flags)
+	return m.u.c.Append(m.name, flags, date, body)
+}
+
+func (m *wireMailbox) UpdateMessagesFlags(uid bool, seqset *imap.SeqSet, operation imap.FlagsOp, flags []string) error {
+	m.u.lock.Lock()
+	defer m.u.lock.Unlock()
+	if err := m.selectMbox(); err != nil {
+		return err
+	}
+
+	m.u.rememberFlags(flags)
+	flagsValue := make([]interface{}, 0, len(flags))
+	for _, flag := range flags {
+		flagsValue = append(flagsValue, flag)
+	}
+
+	item := imap.FormatFlagsOp(operation, true)
+	if uid {
+		return m.u.c.UidStore(seqset, item, flagsValue, nil)
+	}
+	return m.u.c.Store(seqset, item, flagsValue, nil)
+}
+
+func (m *wireMailbox) CopyMessages(uid bool, seqset *imap.SeqSet, dest string) error {
+	m.u.lock.Lock()
+	defer m.u.lock.Unlock()
+	if err := m.selectMbox(); err != nil {
+		return err
+	}
+	if uid {
+		return m.u.c.UidCopy(seqset, dest)
+	}
+	return m.u.c.Copy(seqset, dest)
+}
+
+func (m *wireMailbox) Expunge() error {
+	m.u.lock.Lock()
+	defer m.u.lock.Unlock()
+	if err := m.selectMbox(); err != nil {
+		return err
+	}
+	return m.u.c.Expunge(nil)
+}
+
+// wireMoveMailbox is used if tested backend implements move.Mailbox.
+type wireMoveMailbox struct {
+	*wireMailbox
+}
+
+func (m wireMoveMailbox) MoveMessages(uid bool, seqset *imap.SeqSet, dest string) error {
+	m.u.lock.Lock()
+	defer m.u.lock.Unlock()
+	if err := m.selectMbox(); err != nil {
+		return err
+	}
+
+	c := move.NewClient(m.u.c)
+	if uid {
+		return c.UidMove(seqset, dest)
+	}
+	return c.Move(seqset, dest)
+}